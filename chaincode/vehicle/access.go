@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Papéis reconhecidos no atributo "role" do certificado do chamador
+const (
+	RoleAdmin        = "admin"        // altera a política de pontuação e administra o contrato
+	RoleDevice       = "device"       // dispositivo de telemetria embarcado em um veículo
+	RoleInsurer      = "insurer"      // seguradora, concede créditos às carteiras
+	RoleOwner        = "owner"        // proprietário, registra veículos e cria suas carteiras
+	RolePartner      = "partner"      // parceiro, mantém itens do catálogo de recompensas e atende vouchers
+	RoleConsumer     = "consumer"     // consumidor de dados, compra acesso à telemetria com a carteira da sua organização
+	RoleFleetManager = "fleetmanager" // gestor de frota, agrupa veículos e acompanha a pontuação da frota
+	RoleDriver       = "driver"       // motorista, conduz veículos em sessões e acumula créditos na própria carteira
+)
+
+// roleAttribute é o atributo do certificado usado no controle de acesso
+const roleAttribute = "role"
+
+// adminMSPs lista as organizações cujas identidades podem exercer o papel de administrador.
+// O atributo role=admin emitido pela CA de qualquer outra organização é ignorado.
+var adminMSPs = map[string]bool{
+	"INMETROMSP": true,
+}
+
+// Caller descreve a identidade que submeteu a transação
+type Caller struct {
+	MSPID string
+	ID    string
+	Role  string
+}
+
+// getCaller extrai MSP, identificador e papel do certificado do chamador
+func getCaller(ctx contractapi.TransactionContextInterface) (*Caller, error) {
+	clientIdentity := ctx.GetClientIdentity()
+
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao obter o MSP do chamador: %s", err)
+	}
+	id, err := clientIdentity.GetID()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao obter a identidade do chamador: %s", err)
+	}
+	role, _, err := clientIdentity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler os atributos do chamador: %s", err)
+	}
+
+	return &Caller{MSPID: mspID, ID: id, Role: role}, nil
+}
+
+// HasRole informa se o chamador exerce um dos papéis, respeitando as organizações autorizadas a administrar
+func (c *Caller) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if c.Role != role {
+			continue
+		}
+		if role == RoleAdmin && !adminMSPs[c.MSPID] {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// permissionDenied monta o erro devolvido quando o chamador não pode executar a operação
+func permissionDenied(operation string, caller *Caller, reason string) error {
+	role := caller.Role
+	if role == "" {
+		role = "sem papel"
+	}
+	return fmt.Errorf("permissão negada: %s não autorizada para %s (%s): %s", operation, caller.MSPID, role, reason)
+}
+
+// requireRole garante que o chamador exerce um dos papéis informados
+func requireRole(ctx contractapi.TransactionContextInterface, operation string, roles ...string) (*Caller, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !caller.HasRole(roles...) {
+		if caller.Role == RoleAdmin && !adminMSPs[caller.MSPID] {
+			return nil, permissionDenied(operation, caller, "o papel admin não é aceito para identidades desta organização")
+		}
+		return nil, permissionDenied(operation, caller, "exige role="+strings.Join(roles, " ou role="))
+	}
+	return caller, nil
+}
+
+// requireDevice garante que o chamador é o dispositivo registrado para o veículo ou, se allowAdmin, um administrador.
+// Veículos não registrados são rejeitados, inclusive para administradores.
+func requireDevice(ctx contractapi.TransactionContextInterface, operation string, idcarro string, allowAdmin bool) (*Caller, *Vehicle, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vehicle == nil {
+		return nil, nil, fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+	if allowAdmin && caller.HasRole(RoleAdmin) {
+		return caller, vehicle, nil
+	}
+	if !caller.HasRole(RoleDevice) {
+		return nil, nil, permissionDenied(operation, caller, "exige o dispositivo (role=device) do veículo "+idcarro)
+	}
+
+	bound, err := isBoundDevice(ctx, vehicle)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bound {
+		return nil, nil, permissionDenied(operation, caller, "o certificado não é o do dispositivo registrado para o veículo "+idcarro)
+	}
+	return caller, vehicle, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// analysisCoverageIndex é o prefixo da chave composta ANALYZED~placa, até onde a telemetria do veículo já foi analisada
+const analysisCoverageIndex = "ANALYZED"
+
+// analysisWindow é a duração (ms) das janelas analisadas por AnalyzeDriverBehavior. As janelas têm tamanho fixo e
+// começam em múltiplos de analysisWindow: como cada detector penaliza no máximo uma vez por janela, um tamanho escolhido
+// pelo dispositivo permitiria diluir as penalidades em janelas longas ou evitar, com janelas curtas, detectores que
+// dependem de várias amostras, como o de zigue-zague.
+const analysisWindow = 60 * 1000
+
+// AnalysisCoverage registra a última janela analisada do veículo. As janelas seguintes precisam começar depois dela
+// sem deixar amostras armazenadas de fora, de modo que cada amostra é analisada exatamente uma vez.
+// A posição da última amostra fica na coleção privada; aqui fica apenas o seu timestamp.
+type AnalysisCoverage struct {
+	VehicleID  string `json:"vehicleId"`
+	AnalyzedTo int64  `json:"analyzedTo"` // fim (inclusive, em ms) da última janela analisada
+	LastSample int64  `json:"lastSample"` // timestamp da última amostra analisada, origem do trecho até a janela seguinte
+	TxID       string `json:"txId"`
+}
+
+// getAnalysisCoverage recupera a cobertura das análises do veículo; devolve cobertura vazia, sem TxID, se nenhuma janela
+// foi analisada
+func getAnalysisCoverage(ctx contractapi.TransactionContextInterface, idcarro string) (*AnalysisCoverage, error) {
+	coverageKey, err := ctx.GetStub().CreateCompositeKey(analysisCoverageIndex, []string{idcarro})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a cobertura das análises: %s", err)
+	}
+
+	coverageJSON, err := ctx.GetStub().GetState(coverageKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a cobertura das análises: %s", err)
+	}
+	coverage := &AnalysisCoverage{VehicleID: idcarro}
+	if coverageJSON == nil {
+		return coverage, nil
+	}
+	err = json.Unmarshal(coverageJSON, coverage)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a cobertura das análises: %s", err)
+	}
+	return coverage, nil
+}
+
+// putAnalysisCoverage grava a cobertura das análises do veículo
+func putAnalysisCoverage(ctx contractapi.TransactionContextInterface, coverage *AnalysisCoverage) error {
+	coverageKey, err := ctx.GetStub().CreateCompositeKey(analysisCoverageIndex, []string{coverage.VehicleID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a cobertura das análises: %s", err)
+	}
+	coverageJSON, err := json.Marshal(coverage)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a cobertura das análises: %s", err)
+	}
+	return ctx.GetStub().PutState(coverageKey, coverageJSON)
+}
+
+// validateAnalysisWindow verifica se a janela segue a grade fixa de analysisWindow e começa depois da última janela analisada
+func validateAnalysisWindow(coverage *AnalysisCoverage, from int64, to int64) error {
+	if from < 0 || from > maxTimestamp || from%analysisWindow != 0 || to != from+analysisWindow-1 {
+		return fmt.Errorf("janela de análise inválida [%d, %d]: use janelas de %d ms que comecem em múltiplos de %d", from, to, analysisWindow, analysisWindow)
+	}
+	if coverage.TxID != "" && from <= coverage.AnalyzedTo {
+		return fmt.Errorf("janela de análise sobrepõe a janela já analisada até %d", coverage.AnalyzedTo)
+	}
+	return nil
+}
+
+// checkAnalysisWindow garante que a janela é válida (validateAnalysisWindow), não pula amostras armazenadas e termina
+// até a última amostra armazenada, para que nenhuma amostra seja incluída depois na janela já analisada
+func checkAnalysisWindow(ctx contractapi.TransactionContextInterface, coverage *AnalysisCoverage, from int64, to int64) error {
+	if err := validateAnalysisWindow(coverage, from, to); err != nil {
+		return err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(telemetryCollection, vehicleDataKey(coverage.VehicleID, coverage.AnalyzedTo+1), vehicleDataKey(coverage.VehicleID, from))
+	if err != nil {
+		return fmt.Errorf("falha ao consultar as amostras do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+	if resultsIterator.HasNext() {
+		return fmt.Errorf("há amostras não analisadas entre %d e %d: a janela deve começar logo após a janela analisada até %d", coverage.AnalyzedTo+1, from-1, coverage.AnalyzedTo)
+	}
+
+	latest, err := getLatestVehicleData(ctx, coverage.VehicleID)
+	if err != nil {
+		return err
+	}
+	if latest == nil || to > latest.TimeStamp {
+		return fmt.Errorf("janela de análise termina depois da última amostra armazenada do veículo %s", coverage.VehicleID)
+	}
+	return nil
+}
+
+// coveredDistance devolve a distância, em quilômetros, percorrida até a última amostra da janela e ainda não contada:
+// o trajeto entre as amostras da janela e o trecho desde a última amostra analisada, se ela ainda estiver armazenada.
+// Assim a distância de cada trecho entra nos agregados uma única vez.
+func coveredDistance(ctx contractapi.TransactionContextInterface, coverage *AnalysisCoverage, samples []VehicleData) (float64, error) {
+	distance := PathDistance(samples)
+	if coverage.LastSample == 0 || len(samples) == 0 {
+		return distance, nil
+	}
+	previous, err := getVehicleDataWindow(ctx, coverage.VehicleID, coverage.LastSample, coverage.LastSample)
+	if err != nil {
+		return 0, err
+	}
+	if len(previous) > 0 {
+		distance += Haversine(previous[0].Latitude, previous[0].Longitude, samples[0].Latitude, samples[0].Longitude)
+	}
+	return distance, nil
+}
+
+// GetAnalysisCoverage consulta até onde a telemetria do veículo já foi analisada;
+// exige o dispositivo vinculado ao veículo ou um administrador
+func (s *SmartContract) GetAnalysisCoverage(ctx contractapi.TransactionContextInterface, idcarro string) (*AnalysisCoverage, error) {
+	if _, _, err := requireDevice(ctx, "GetAnalysisCoverage", idcarro, true); err != nil {
+		return nil, err
+	}
+	return getAnalysisCoverage(ctx, idcarro)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestValidateAnalysisWindow(t *testing.T) {
+	first := &AnalysisCoverage{VehicleID: "ABC1234"}
+	analyzed := &AnalysisCoverage{VehicleID: "ABC1234", AnalyzedTo: 2*analysisWindow - 1, TxID: "tx1"}
+	lastWindow := maxTimestamp - maxTimestamp%analysisWindow
+
+	tests := []struct {
+		name     string
+		coverage *AnalysisCoverage
+		from, to int64
+		wantErr  bool
+	}{
+		{"primeira janela na origem", first, 0, analysisWindow - 1, false},
+		{"primeira janela", first, 5 * analysisWindow, 6*analysisWindow - 1, false},
+		{"início fora da grade", first, analysisWindow + 1, 2 * analysisWindow, true},
+		{"janela curta", first, analysisWindow, 2*analysisWindow - 2, true},
+		{"janela longa", first, analysisWindow, 3*analysisWindow - 1, true},
+		{"início negativo", first, -analysisWindow, -1, true},
+		{"última janela da grade", first, lastWindow, lastWindow + analysisWindow - 1, false},
+		{"início acima do máximo", first, lastWindow + analysisWindow, lastWindow + 2*analysisWindow - 1, true},
+		{"janela seguinte à analisada", analyzed, 2 * analysisWindow, 3*analysisWindow - 1, false},
+		{"janela já analisada", analyzed, analysisWindow, 2*analysisWindow - 1, true},
+		{"janela anterior à analisada", analyzed, 0, analysisWindow - 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAnalysisWindow(tt.coverage, tt.from, tt.to); (err != nil) != tt.wantErr {
+				t.Errorf("validateAnalysisWindow(%d, %d) erro = %v, esperado erro: %v", tt.from, tt.to, err, tt.wantErr)
+			}
+		})
+	}
+}
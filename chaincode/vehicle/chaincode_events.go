@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Nomes dos eventos de chaincode emitidos pelo contrato.
+// O Fabric entrega apenas um evento por transação (o último SetEvent prevalece),
+// por isso cada transação emite um único evento com todas as informações relevantes.
+const (
+	EventNameBehaviorDetected   = "BehaviorDetected"
+	EventNameWalletCredited     = "WalletCredited"
+	EventNameWalletCreated      = "WalletCreated"
+	EventNameCreditsTransferred = "CreditsTransferred"
+	EventNameRewardRedeemed     = "RewardRedeemed"
+	EventNameDataPurchased      = "DataPurchased"
+	EventNameVehicleDataErased  = "VehicleDataErased" // payload: o ErasureReceipt da eliminação
+)
+
+// BehaviorDetectedPayload é emitido quando a análise de uma janela encontra ao menos uma detecção
+type BehaviorDetectedPayload struct {
+	VehicleID   string           `json:"vehicleId"`
+	Events      []*BehaviorEvent `json:"events"`
+	CreditDelta int              `json:"creditDelta"` // saldo líquido da análise, incluindo recompensas e lançamentos nas carteiras dos motoristas
+	Balance     int              `json:"balance"`     // saldo da carteira do veículo
+}
+
+// WalletCreditedPayload é emitido quando o saldo de uma carteira muda sem nenhuma detecção associada
+type WalletCreditedPayload struct {
+	VehicleID string `json:"vehicleId"`
+	Amount    int    `json:"amount"`
+	Balance   int    `json:"balance"`
+}
+
+// WalletCreatedPayload é emitido quando uma carteira é criada
+type WalletCreatedPayload struct {
+	VehicleID string `json:"vehicleId"`
+	Balance   int    `json:"balance"`
+}
+
+// emitEvent serializa o payload e o registra como evento de chaincode da transação
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o evento %s: %s", name, err)
+	}
+
+	err = ctx.GetStub().SetEvent(name, payloadJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao emitir o evento %s: %s", name, err)
+	}
+	return nil
+}
+
+// CreditsTransferredPayload é emitido quando créditos são transferidos entre carteiras
+type CreditsTransferredPayload struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      int    `json:"amount"`
+	FromBalance int    `json:"fromBalance"`
+	ToBalance   int    `json:"toBalance"`
+}
+
+// RewardRedeemedPayload é emitido quando créditos são trocados por um item do catálogo
+type RewardRedeemedPayload struct {
+	Voucher *Voucher `json:"voucher"`
+	Balance int      `json:"balance"`
+}
+
+// DataPurchasedPayload é emitido quando uma organização compra acesso à telemetria de um veículo
+type DataPurchasedPayload struct {
+	Purchase      *DataPurchase `json:"purchase"`
+	BuyerBalance  int           `json:"buyerBalance"`
+	SellerBalance int           `json:"sellerBalance"`
+}
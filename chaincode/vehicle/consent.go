@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves compostas de consentimento
+const (
+	consentIndex    = "CONSENT"    // chave composta CONSENT~placa~MSP~categoria, consentimento em vigor
+	consentLogIndex = "CONSENTLOG" // chave composta CONSENTLOG~placa~timestamp~transação, histórico para auditoria
+)
+
+// Categorias de dados sujeitas a consentimento
+const (
+	CategoryLocation      = "location"      // latitude, longitude e direção
+	CategorySpeed         = "speed"         // velocidade
+	CategoryAccelerometer = "accelerometer" // acelerações nos três eixos
+	CategoryScores        = "scores"        // eventos de comportamento, carteira e extrato
+)
+
+// dataCategories lista as categorias na ordem usada em mensagens e na redação de dados
+var dataCategories = []string{CategoryLocation, CategorySpeed, CategoryAccelerometer, CategoryScores}
+
+// Ações registradas no histórico de consentimento
+const (
+	ConsentGranted = "GRANT"
+	ConsentRevoked = "REVOKE"
+)
+
+// Consent autoriza a organização GranteeMSP a ver uma categoria de dados do veículo, para uma finalidade, até ValidUntil
+type Consent struct {
+	VehicleID  string `json:"vehicleId"`
+	GranteeMSP string `json:"granteeMsp"`
+	Category   string `json:"category"`
+	Purpose    string `json:"purpose"`
+	ValidUntil int64  `json:"validUntil"` // em milissegundos
+	GrantedBy  string `json:"grantedBy"`
+	GrantedAt  int64  `json:"grantedAt"`
+}
+
+// ConsentAuditEntry registra uma concessão ou revogação de consentimento
+type ConsentAuditEntry struct {
+	Action    string   `json:"action"`
+	Consent   *Consent `json:"consent"`
+	CallerMSP string   `json:"callerMsp"`
+	CallerID  string   `json:"callerId"`
+	TxID      string   `json:"txId"`
+	TimeStamp int64    `json:"timestamp"`
+}
+
+// validateCategory verifica se a categoria de dados é conhecida
+func validateCategory(category string) error {
+	for _, known := range dataCategories {
+		if category == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("categoria de dados inválida %q: use %v", category, dataCategories)
+}
+
+// allCategories concede todas as categorias, como para o proprietário e administradores
+func allCategories() map[string]bool {
+	categories := map[string]bool{}
+	for _, category := range dataCategories {
+		categories[category] = true
+	}
+	return categories
+}
+
+// getConsent recupera o consentimento em vigor; devolve nil se não houver
+func getConsent(ctx contractapi.TransactionContextInterface, idcarro string, granteeMSP string, category string) (*Consent, error) {
+	consentKey, err := ctx.GetStub().CreateCompositeKey(consentIndex, []string{idcarro, granteeMSP, category})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para o consentimento: %s", err)
+	}
+
+	consentJSON, err := ctx.GetStub().GetState(consentKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o consentimento: %s", err)
+	}
+	if consentJSON == nil {
+		return nil, nil
+	}
+
+	var consent Consent
+	err = json.Unmarshal(consentJSON, &consent)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar o consentimento: %s", err)
+	}
+	return &consent, nil
+}
+
+// getConsents recupera os consentimentos do veículo; se granteeMSP for informado, apenas os dessa organização
+func getConsents(ctx contractapi.TransactionContextInterface, idcarro string, granteeMSP string) ([]*Consent, error) {
+	attributes := []string{idcarro}
+	if granteeMSP != "" {
+		attributes = append(attributes, granteeMSP)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentIndex, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar os consentimentos: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	consents := []*Consent{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler os consentimentos: %s", err)
+		}
+
+		var consent Consent
+		err = json.Unmarshal(queryResponse.Value, &consent)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o consentimento: %s", err)
+		}
+		consents = append(consents, &consent)
+	}
+	return consents, nil
+}
+
+// logConsent acrescenta a ação ao histórico de consentimento do veículo
+func logConsent(ctx contractapi.TransactionContextInterface, caller *Caller, action string, consent *Consent) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry := ConsentAuditEntry{
+		Action:    action,
+		Consent:   consent,
+		CallerMSP: caller.MSPID,
+		CallerID:  caller.ID,
+		TxID:      ctx.GetStub().GetTxID(),
+		TimeStamp: now,
+	}
+
+	logKey, err := ctx.GetStub().CreateCompositeKey(consentLogIndex, []string{consent.VehicleID, fmt.Sprintf("%019d", now), entry.TxID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o histórico de consentimento: %s", err)
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o histórico de consentimento: %s", err)
+	}
+
+	return ctx.GetStub().PutState(logKey, entryJSON)
+}
+
+// consentedCategories devolve as categorias que o chamador pode ver nos dados do veículo: todas para o
+// proprietário e administradores, ou as que têm consentimento vigente para a organização do chamador
+func consentedCategories(ctx contractapi.TransactionContextInterface, caller *Caller, vehicle *Vehicle) (map[string]bool, error) {
+	if vehicle.IsOwner(caller) || caller.HasRole(RoleAdmin) {
+		return allCategories(), nil
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	consents, err := getConsents(ctx, vehicle.Plate, caller.MSPID)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := map[string]bool{}
+	for _, consent := range consents {
+		if consent.ValidUntil > now {
+			categories[consent.Category] = true
+		}
+	}
+	return categories, nil
+}
+
+// requireConsent garante que o chamador pode ver a categoria de dados do veículo e devolve as categorias permitidas
+func requireConsent(ctx contractapi.TransactionContextInterface, operation string, idcarro string, category string) (map[string]bool, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle == nil {
+		return nil, fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+
+	categories, err := consentedCategories(ctx, caller, vehicle)
+	if err != nil {
+		return nil, err
+	}
+	if !categories[category] {
+		return nil, permissionDenied(operation, caller, fmt.Sprintf("sem consentimento do proprietário para a categoria %s do veículo %s", category, idcarro))
+	}
+	return categories, nil
+}
+
+// GrantConsent autoriza a organização granteeMSP a ver uma categoria de dados do veículo até validUntil
+// (em milissegundos); exige o proprietário. Um consentimento existente para a mesma categoria é substituído.
+func (s *SmartContract) GrantConsent(ctx contractapi.TransactionContextInterface, idcarro string, granteeMSP string, category string, purpose string, validUntil int64) error {
+	caller, _, err := requireVehicleOwner(ctx, "GrantConsent", idcarro, false)
+	if err != nil {
+		return err
+	}
+	if granteeMSP == "" {
+		return fmt.Errorf("a organização autorizada é obrigatória")
+	}
+	if err := validateCategory(category); err != nil {
+		return err
+	}
+	if purpose == "" {
+		return fmt.Errorf("a finalidade do consentimento é obrigatória")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if validUntil <= now {
+		return fmt.Errorf("validade do consentimento %d deve ser posterior ao momento atual %d", validUntil, now)
+	}
+
+	consent := &Consent{
+		VehicleID:  idcarro,
+		GranteeMSP: granteeMSP,
+		Category:   category,
+		Purpose:    purpose,
+		ValidUntil: validUntil,
+		GrantedBy:  caller.ID,
+		GrantedAt:  now,
+	}
+
+	consentKey, err := ctx.GetStub().CreateCompositeKey(consentIndex, []string{idcarro, granteeMSP, category})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o consentimento: %s", err)
+	}
+	consentJSON, err := json.Marshal(consent)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o consentimento: %s", err)
+	}
+	err = ctx.GetStub().PutState(consentKey, consentJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao armazenar o consentimento: %s", err)
+	}
+
+	return logConsent(ctx, caller, ConsentGranted, consent)
+}
+
+// RevokeConsent revoga o consentimento da organização granteeMSP para uma categoria de dados do veículo; exige o proprietário
+func (s *SmartContract) RevokeConsent(ctx contractapi.TransactionContextInterface, idcarro string, granteeMSP string, category string) error {
+	caller, _, err := requireVehicleOwner(ctx, "RevokeConsent", idcarro, false)
+	if err != nil {
+		return err
+	}
+
+	consent, err := getConsent(ctx, idcarro, granteeMSP, category)
+	if err != nil {
+		return err
+	}
+	if consent == nil {
+		return fmt.Errorf("não há consentimento de %s para a categoria %s do veículo %s", granteeMSP, category, idcarro)
+	}
+
+	consentKey, err := ctx.GetStub().CreateCompositeKey(consentIndex, []string{idcarro, granteeMSP, category})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o consentimento: %s", err)
+	}
+	err = ctx.GetStub().DelState(consentKey)
+	if err != nil {
+		return fmt.Errorf("falha ao remover o consentimento: %s", err)
+	}
+
+	return logConsent(ctx, caller, ConsentRevoked, consent)
+}
+
+// ListConsents consulta os consentimentos em vigor do veículo; exige o proprietário ou um administrador
+func (s *SmartContract) ListConsents(ctx contractapi.TransactionContextInterface, idcarro string) ([]*Consent, error) {
+	if _, _, err := requireVehicleOwner(ctx, "ListConsents", idcarro, true); err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	consents, err := getConsents(ctx, idcarro, "")
+	if err != nil {
+		return nil, err
+	}
+
+	active := []*Consent{}
+	for _, consent := range consents {
+		if consent.ValidUntil > now {
+			active = append(active, consent)
+		}
+	}
+	return active, nil
+}
+
+// GetConsentHistory consulta o histórico completo de concessões e revogações do veículo, em ordem cronológica;
+// exige o proprietário ou um administrador
+func (s *SmartContract) GetConsentHistory(ctx contractapi.TransactionContextInterface, idcarro string) ([]*ConsentAuditEntry, error) {
+	if _, _, err := requireVehicleOwner(ctx, "GetConsentHistory", idcarro, true); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentLogIndex, []string{idcarro})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar o histórico de consentimento: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	history := []*ConsentAuditEntry{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler o histórico de consentimento: %s", err)
+		}
+
+		var entry ConsentAuditEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o histórico de consentimento: %s", err)
+		}
+		history = append(history, &entry)
+	}
+	return history, nil
+}
+
+// Redact remove da leitura os campos cujas categorias o chamador não pode ver e lista as categorias removidas
+func (d *VehicleData) Redact(categories map[string]bool) {
+	if !categories[CategoryLocation] {
+		d.Latitude, d.Longitude, d.Direction = 0, 0, 0
+		d.Redacted = append(d.Redacted, CategoryLocation)
+	}
+	if !categories[CategorySpeed] {
+		d.Speed = 0
+		d.Redacted = append(d.Redacted, CategorySpeed)
+	}
+	if !categories[CategoryAccelerometer] {
+		d.AccelX, d.AccelY, d.AccelZ = 0, 0, 0
+		d.Redacted = append(d.Redacted, CategoryAccelerometer)
+	}
+}
+
+// hasTelemetryCategory informa se alguma categoria de telemetria bruta foi concedida
+func hasTelemetryCategory(categories map[string]bool) bool {
+	return categories[CategoryLocation] || categories[CategorySpeed] || categories[CategoryAccelerometer]
+}
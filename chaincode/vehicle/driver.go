@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves de motoristas e sessões de condução
+const (
+	driverIndex        = "DRIVER"        // chave composta DRIVER~motorista
+	sessionIndex       = "SESSION"       // chave simples SESSION~placa~início, uma por sessão
+	activeSessionIndex = "ACTIVESESSION" // chave composta ACTIVESESSION~placa, chave da sessão em andamento
+	driverAuthIndex    = "DRIVERAUTH"    // chave composta DRIVERAUTH~placa~motorista, presente enquanto o motorista aceita conduzir o veículo
+)
+
+// maxSessionDuration limita o período atribuído a uma sessão: amostras posteriores a StartedAt+maxSessionDuration
+// não são atribuídas ao motorista, mesmo que a sessão não tenha sido encerrada
+const maxSessionDuration = 24 * hourMillis
+
+// driverIDPattern restringe o identificador do motorista; sem ':', a carteira do motorista não colide com outras
+var driverIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// driverWalletPrefix identifica as carteiras de motoristas
+const driverWalletPrefix = "driver:"
+
+// driverWalletID devolve o identificador da carteira do motorista
+func driverWalletID(driverID string) string {
+	return driverWalletPrefix + driverID
+}
+
+// Driver é um motorista (role=driver) que pode conduzir veículos de terceiros e acumula créditos na própria carteira
+type Driver struct {
+	ID           string `json:"id"`
+	MSPID        string `json:"mspId"`
+	Identity     string `json:"identity"` // identificador do certificado do motorista
+	RegisteredAt int64  `json:"registeredAt"`
+}
+
+// IsDriver informa se o chamador é o motorista
+func (d *Driver) IsDriver(caller *Caller) bool {
+	return d.MSPID == caller.MSPID && d.Identity == caller.ID
+}
+
+// DrivingSession atribui ao motorista a telemetria e as detecções do veículo entre StartedAt e EndedAt
+type DrivingSession struct {
+	ID        string `json:"id"` // identificador da transação que iniciou a sessão
+	VehicleID string `json:"vehicleId"`
+	DriverID  string `json:"driverId"`
+	StartedAt int64  `json:"startedAt"`
+	StartedBy string `json:"startedBy"`
+	EndedAt   int64  `json:"endedAt,omitempty" metadata:"endedAt,optional"` // zero enquanto a sessão estiver em andamento
+	EndedBy   string `json:"endedBy,omitempty" metadata:"endedBy,optional"`
+}
+
+// Covers informa se a amostra com o timestamp informado pertence à sessão
+func (s *DrivingSession) Covers(timestamp int64) bool {
+	end := s.StartedAt + maxSessionDuration
+	if s.EndedAt != 0 && s.EndedAt < end {
+		end = s.EndedAt
+	}
+	return timestamp >= s.StartedAt && timestamp <= end
+}
+
+// sessionKey monta a chave da sessão; o início preenchido com zeros ordena as sessões do veículo no tempo
+func sessionKey(idcarro string, startedAt int64) string {
+	return fmt.Sprintf("%s~%s~%019d", sessionIndex, idcarro, startedAt)
+}
+
+// getDriver recupera um motorista, ou nil se ele não estiver registrado
+func getDriver(ctx contractapi.TransactionContextInterface, driverID string) (*Driver, error) {
+	driverKey, err := ctx.GetStub().CreateCompositeKey(driverIndex, []string{driverID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para o motorista: %s", err)
+	}
+
+	driverJSON, err := ctx.GetStub().GetState(driverKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o motorista: %s", err)
+	}
+	if driverJSON == nil {
+		return nil, nil
+	}
+
+	var driver Driver
+	err = json.Unmarshal(driverJSON, &driver)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar o motorista: %s", err)
+	}
+	return &driver, nil
+}
+
+// requireDriver garante que o chamador é o motorista (ou um administrador, se allowAdmin) e devolve o motorista
+func requireDriver(ctx contractapi.TransactionContextInterface, operation string, driverID string, allowAdmin bool) (*Caller, *Driver, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	driver, err := getDriver(ctx, driverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if driver == nil {
+		return nil, nil, fmt.Errorf("motorista %s não registrado", driverID)
+	}
+	if driver.IsDriver(caller) || (allowAdmin && caller.HasRole(RoleAdmin)) {
+		return caller, driver, nil
+	}
+	return nil, nil, permissionDenied(operation, caller, "exige o motorista "+driverID)
+}
+
+// getSession recupera uma sessão pela chave, ou nil se ela não existir
+func getSession(ctx contractapi.TransactionContextInterface, key string) (*DrivingSession, error) {
+	sessionJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a sessão de condução: %s", err)
+	}
+	if sessionJSON == nil {
+		return nil, nil
+	}
+
+	var session DrivingSession
+	err = json.Unmarshal(sessionJSON, &session)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a sessão de condução: %s", err)
+	}
+	return &session, nil
+}
+
+// putSession grava a sessão
+func putSession(ctx contractapi.TransactionContextInterface, session *DrivingSession) error {
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a sessão de condução: %s", err)
+	}
+	return ctx.GetStub().PutState(sessionKey(session.VehicleID, session.StartedAt), sessionJSON)
+}
+
+// getActiveSession recupera a sessão em andamento do veículo, ou nil se não houver
+func getActiveSession(ctx contractapi.TransactionContextInterface, idcarro string) (string, *DrivingSession, error) {
+	activeKey, err := ctx.GetStub().CreateCompositeKey(activeSessionIndex, []string{idcarro})
+	if err != nil {
+		return "", nil, fmt.Errorf("erro ao criar chave composta para a sessão em andamento: %s", err)
+	}
+	key, err := ctx.GetStub().GetState(activeKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("falha ao ler a sessão em andamento: %s", err)
+	}
+	if key == nil {
+		return activeKey, nil, nil
+	}
+	session, err := getSession(ctx, string(key))
+	return activeKey, session, err
+}
+
+// getSessionsStartedBetween recupera as sessões do veículo iniciadas entre from e to (inclusive).
+// As sessões que podem cobrir amostras entre from e to são as iniciadas entre from-maxSessionDuration e to.
+func getSessionsStartedBetween(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) ([]*DrivingSession, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(sessionKey(idcarro, from), sessionKey(idcarro, to+1))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as sessões de condução: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	sessions := []*DrivingSession{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre as sessões de condução: %s", err)
+		}
+
+		var session DrivingSession
+		err = json.Unmarshal(queryResponse.Value, &session)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a sessão de condução: %s", err)
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+// driverAt devolve o motorista cuja sessão cobre o timestamp, ou vazio se nenhuma sessão o cobrir
+func driverAt(sessions []*DrivingSession, timestamp int64) string {
+	for _, session := range sessions {
+		if session.Covers(timestamp) {
+			return session.DriverID
+		}
+	}
+	return ""
+}
+
+// windowDriver devolve o motorista ao qual todas as amostras são atribuídas, ou vazio se elas não
+// pertencerem todas à sessão de um mesmo motorista
+func windowDriver(sessions []*DrivingSession, samples []VehicleData) string {
+	driverID := ""
+	for i, sample := range samples {
+		current := driverAt(sessions, sample.TimeStamp)
+		if current == "" || (i > 0 && current != driverID) {
+			return ""
+		}
+		driverID = current
+	}
+	return driverID
+}
+
+// postDriverCreditEntries lança nas carteiras dos motoristas os lançamentos atribuídos a cada um;
+// as chaves de entries são os motoristas, processados em ordem para que a transação seja determinística
+func postDriverCreditEntries(ctx contractapi.TransactionContextInterface, entries map[string][]*CreditEntry) error {
+	driverIDs := make([]string, 0, len(entries))
+	for driverID := range entries {
+		driverIDs = append(driverIDs, driverID)
+	}
+	sort.Strings(driverIDs)
+
+	for _, driverID := range driverIDs {
+		if _, err := postCreditEntries(ctx, driverWalletID(driverID), entries[driverID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterDriver registra o chamador (role=driver) como motorista e cria a sua carteira
+func (s *SmartContract) RegisterDriver(ctx contractapi.TransactionContextInterface, driverID string) error {
+	caller, err := requireRole(ctx, "RegisterDriver", RoleDriver)
+	if err != nil {
+		return err
+	}
+	if !driverIDPattern.MatchString(driverID) {
+		return fmt.Errorf("identificador de motorista inválido %q: use de 1 a 32 letras, dígitos ou '-'", driverID)
+	}
+	existing, err := getDriver(ctx, driverID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("motorista %s já registrado", driverID)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	driverKey, err := ctx.GetStub().CreateCompositeKey(driverIndex, []string{driverID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o motorista: %s", err)
+	}
+	driverJSON, err := json.Marshal(Driver{ID: driverID, MSPID: caller.MSPID, Identity: caller.ID, RegisteredAt: now})
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o motorista: %s", err)
+	}
+	if err := ctx.GetStub().PutState(driverKey, driverJSON); err != nil {
+		return fmt.Errorf("falha ao armazenar o motorista: %s", err)
+	}
+
+	walletID := driverWalletID(driverID)
+	if err := putWallet(ctx, walletID, &VehicleWallet{}); err != nil {
+		return err
+	}
+	return emitEvent(ctx, EventNameWalletCreated, WalletCreatedPayload{VehicleID: walletID})
+}
+
+// GetDriver consulta um motorista; exige o próprio motorista ou um administrador
+func (s *SmartContract) GetDriver(ctx contractapi.TransactionContextInterface, driverID string) (*Driver, error) {
+	_, driver, err := requireDriver(ctx, "GetDriver", driverID, true)
+	if err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+// driverAuthKey monta a chave da autorização do motorista para conduzir o veículo
+func driverAuthKey(ctx contractapi.TransactionContextInterface, idcarro string, driverID string) (string, error) {
+	authKey, err := ctx.GetStub().CreateCompositeKey(driverAuthIndex, []string{idcarro, driverID})
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar chave composta para a autorização do motorista: %s", err)
+	}
+	return authKey, nil
+}
+
+// AuthorizeVehicle registra que o motorista aceita conduzir o veículo, permitindo que o proprietário inicie
+// sessões de condução em seu nome; exige o próprio motorista
+func (s *SmartContract) AuthorizeVehicle(ctx contractapi.TransactionContextInterface, driverID string, idcarro string) error {
+	if _, _, err := requireDriver(ctx, "AuthorizeVehicle", driverID, false); err != nil {
+		return err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	if vehicle == nil {
+		return fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+
+	authKey, err := driverAuthKey(ctx, idcarro, driverID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(authKey, []byte(ctx.GetStub().GetTxID())); err != nil {
+		return fmt.Errorf("falha ao armazenar a autorização do motorista: %s", err)
+	}
+	return nil
+}
+
+// RevokeVehicleAuthorization retira a autorização do motorista para o veículo; a sessão em andamento, se houver,
+// continua até ser encerrada. Exige o próprio motorista.
+func (s *SmartContract) RevokeVehicleAuthorization(ctx contractapi.TransactionContextInterface, driverID string, idcarro string) error {
+	if _, _, err := requireDriver(ctx, "RevokeVehicleAuthorization", driverID, false); err != nil {
+		return err
+	}
+
+	authKey, err := driverAuthKey(ctx, idcarro, driverID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(authKey); err != nil {
+		return fmt.Errorf("falha ao apagar a autorização do motorista: %s", err)
+	}
+	return nil
+}
+
+// StartDrivingSession entrega o veículo ao motorista: a partir de agora, as detecções sobre a telemetria do
+// veículo também são lançadas na carteira do motorista. Exige o proprietário do veículo ou um administrador,
+// e que o motorista tenha autorizado o veículo com AuthorizeVehicle.
+func (s *SmartContract) StartDrivingSession(ctx contractapi.TransactionContextInterface, idcarro string, driverID string) (*DrivingSession, error) {
+	caller, _, err := requireVehicleOwner(ctx, "StartDrivingSession", idcarro, true)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := getDriver(ctx, driverID)
+	if err != nil {
+		return nil, err
+	}
+	if driver == nil {
+		return nil, fmt.Errorf("motorista %s não registrado", driverID)
+	}
+	authKey, err := driverAuthKey(ctx, idcarro, driverID)
+	if err != nil {
+		return nil, err
+	}
+	authorization, err := ctx.GetStub().GetState(authKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a autorização do motorista: %s", err)
+	}
+	if authorization == nil {
+		return nil, fmt.Errorf("motorista %s não autorizou a condução do veículo %s", driverID, idcarro)
+	}
+
+	activeKey, active, err := getActiveSession(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return nil, fmt.Errorf("veículo %s já está em uma sessão de condução com o motorista %s", idcarro, active.DriverID)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := getSession(ctx, sessionKey(idcarro, now))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("já existe uma sessão do veículo %s iniciada em %d", idcarro, now)
+	}
+
+	session := &DrivingSession{
+		ID:        ctx.GetStub().GetTxID(),
+		VehicleID: idcarro,
+		DriverID:  driverID,
+		StartedAt: now,
+		StartedBy: caller.ID,
+	}
+	if err := putSession(ctx, session); err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(activeKey, []byte(sessionKey(idcarro, now))); err != nil {
+		return nil, fmt.Errorf("falha ao armazenar a sessão em andamento: %s", err)
+	}
+	return session, nil
+}
+
+// EndDrivingSession encerra a sessão em andamento do veículo; exige o proprietário do veículo,
+// um administrador ou o motorista da sessão
+func (s *SmartContract) EndDrivingSession(ctx contractapi.TransactionContextInterface, idcarro string) (*DrivingSession, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle == nil {
+		return nil, fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+	activeKey, session, err := getActiveSession(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("veículo %s não está em uma sessão de condução", idcarro)
+	}
+	if !vehicle.IsOwner(caller) && !caller.HasRole(RoleAdmin) {
+		if _, _, err := requireDriver(ctx, "EndDrivingSession", session.DriverID, false); err != nil {
+			return nil, permissionDenied("EndDrivingSession", caller, "exige o proprietário do veículo "+idcarro+" ou o motorista da sessão")
+		}
+	}
+
+	session.EndedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session.EndedBy = caller.ID
+	if err := putSession(ctx, session); err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().DelState(activeKey); err != nil {
+		return nil, fmt.Errorf("falha ao encerrar a sessão em andamento: %s", err)
+	}
+	return session, nil
+}
+
+// GetDrivingSessions consulta as sessões do veículo iniciadas entre from e to (inclusive);
+// exige o proprietário do veículo ou um administrador
+func (s *SmartContract) GetDrivingSessions(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) ([]*DrivingSession, error) {
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if _, _, err := requireVehicleOwner(ctx, "GetDrivingSessions", idcarro, true); err != nil {
+		return nil, err
+	}
+
+	return getSessionsStartedBetween(ctx, idcarro, from, to)
+}
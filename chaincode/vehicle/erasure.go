@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// erasureIndex é o prefixo da chave composta ERASURE~placa~transação, comprovante de cada eliminação
+const erasureIndex = "ERASURE"
+
+// erasureRetained descreve, no comprovante, os registros do veículo que permanecem no ledger após a eliminação
+var erasureRetained = []string{
+	"registro do veículo, vínculo com a frota e autorizações de motoristas",
+	"consentimentos e histórico de consentimentos",
+	"oferta e compras de dados, com os intervalos comprados",
+	"carteira e extrato de créditos do veículo e dos motoristas, sem as janelas, os eventos e o veículo dos lançamentos de análises",
+	"agregados diários de condução dos motoristas, que não identificam o veículo",
+	"comprovantes de eliminação",
+}
+
+// ErasureReceipt comprova a eliminação da telemetria pessoal de um veículo e guarda apenas agregados
+// anônimos dos dados eliminados. A carteira e o extrato de créditos do veículo são mantidos; Retained lista
+// o que permanece no ledger.
+type ErasureReceipt struct {
+	VehicleID         string         `json:"vehicleId"`
+	CallerMSP         string         `json:"callerMsp"`
+	CallerID          string         `json:"callerId"`
+	TxID              string         `json:"txId"`
+	TimeStamp         int64          `json:"timestamp"`
+	SampleCount       int            `json:"sampleCount"`
+	FirstSample       int64          `json:"firstSample"` // timestamp da primeira amostra eliminada
+	LastSample        int64          `json:"lastSample"`  // timestamp da última amostra eliminada
+	AverageSpeed      float64        `json:"averageSpeed"`
+	MaxSpeed          float64        `json:"maxSpeed"`
+	EventCount        int            `json:"eventCount"`
+	EventCounts       map[string]int `json:"eventCounts"`       // eventos de comportamento eliminados, por tipo
+	TripCount         int            `json:"tripCount"`         // viagens eliminadas
+	SessionCount      int            `json:"sessionCount"`      // sessões de condução eliminadas
+	StatsDays         int            `json:"statsDays"`         // dias de agregados de condução do veículo eliminados
+	AnonymizedEntries int            `json:"anonymizedEntries"` // lançamentos de análises sem janela, evento e veículo
+	Retained          []string       `json:"retained"`
+}
+
+// erasePrivateSamples apaga da coleção privada as amostras do veículo e a última leitura, acumulando os agregados no comprovante.
+// O shim usado por este contrato não oferece PurgePrivateData (Fabric 2.5). DelPrivateData remove os valores do world
+// state privado, mas os peers da coleção ainda guardam os valores gravados em blocos anteriores até que o blockToLive
+// da coleção (ver collections_config.json) os expurgue; só então a eliminação está completa.
+func erasePrivateSamples(ctx contractapi.TransactionContextInterface, receipt *ErasureReceipt) error {
+	idcarro := receipt.VehicleID
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(telemetryCollection, vehicleDataKey(idcarro, 0), vehicleDataKey(idcarro, math.MaxInt64))
+	if err != nil {
+		return fmt.Errorf("falha ao consultar as amostras do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	keys := []string{}
+	speedSum := 0.0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("falha ao iterar sobre as amostras do veículo: %s", err)
+		}
+
+		var vehicleData VehicleData
+		err = json.Unmarshal(queryResponse.Value, &vehicleData)
+		if err != nil {
+			return fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+		}
+		if receipt.SampleCount == 0 {
+			receipt.FirstSample = vehicleData.TimeStamp
+		}
+		receipt.LastSample = vehicleData.TimeStamp
+		receipt.SampleCount++
+		speedSum += vehicleData.Speed
+		receipt.MaxSpeed = math.Max(receipt.MaxSpeed, vehicleData.Speed)
+		keys = append(keys, queryResponse.Key)
+	}
+	if receipt.SampleCount > 0 {
+		receipt.AverageSpeed = speedSum / float64(receipt.SampleCount)
+	}
+
+	latestKey, err := ctx.GetStub().CreateCompositeKey(latestDataIndex, []string{idcarro})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a última leitura: %s", err)
+	}
+	keys = append(keys, latestKey)
+
+	for _, key := range keys {
+		if err := ctx.GetStub().DelPrivateData(telemetryCollection, key); err != nil {
+			return fmt.Errorf("falha ao eliminar os dados privados do veículo: %s", err)
+		}
+	}
+	return nil
+}
+
+// deleteStateRange apaga do world state público as chaves no intervalo [startKey, endKey) e devolve os valores apagados
+func deleteStateRange(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ([][]byte, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar os registros do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	values := [][]byte{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre os registros do veículo: %s", err)
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return nil, fmt.Errorf("falha ao eliminar o registro %s: %s", queryResponse.Key, err)
+		}
+		values = append(values, queryResponse.Value)
+	}
+	return values, nil
+}
+
+// eraseDrivingStats apaga os agregados diários de condução do veículo e a distância correspondente na coleção privada,
+// além da cobertura das análises, que guarda o timestamp da última amostra analisada
+func eraseDrivingStats(ctx contractapi.TransactionContextInterface, receipt *ErasureReceipt) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(drivingStatsIndex, []string{receipt.VehicleID})
+	if err != nil {
+		return fmt.Errorf("falha ao consultar os agregados de condução do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("falha ao iterar sobre os agregados de condução do veículo: %s", err)
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return fmt.Errorf("falha ao eliminar os agregados de condução: %s", err)
+		}
+		if err := ctx.GetStub().DelPrivateData(telemetryCollection, queryResponse.Key); err != nil {
+			return fmt.Errorf("falha ao eliminar a distância dos agregados de condução: %s", err)
+		}
+		receipt.StatsDays++
+	}
+
+	coverageKey, err := ctx.GetStub().CreateCompositeKey(analysisCoverageIndex, []string{receipt.VehicleID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a cobertura das análises: %s", err)
+	}
+	if err := ctx.GetStub().DelState(coverageKey); err != nil {
+		return fmt.Errorf("falha ao eliminar a cobertura das análises: %s", err)
+	}
+	return nil
+}
+
+// anonymizeCreditEntries remove a janela, o evento e o veículo dos lançamentos de análises da carteira originados pela
+// telemetria do veículo, mantendo valor, motivo e saldo, e soma ao comprovante os lançamentos alterados
+func anonymizeCreditEntries(ctx contractapi.TransactionContextInterface, walletID string, receipt *ErasureReceipt) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(creditEntryIndex, []string{walletID})
+	if err != nil {
+		return fmt.Errorf("falha ao consultar o extrato da carteira %s: %s", walletID, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("falha ao iterar sobre o extrato da carteira %s: %s", walletID, err)
+		}
+
+		var entry CreditEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return fmt.Errorf("falha ao desserializar o lançamento: %s", err)
+		}
+		if entry.Reason != ReasonBehaviorEvent && entry.Reason != ReasonSafeDrivingReward {
+			continue
+		}
+		if walletID != receipt.VehicleID && entry.VehicleID != receipt.VehicleID {
+			continue
+		}
+		if entry.WindowFrom == 0 && entry.WindowTo == 0 && entry.EventID == "" && entry.VehicleID == "" {
+			continue
+		}
+
+		entry.WindowFrom, entry.WindowTo, entry.EventID, entry.VehicleID = 0, 0, "", ""
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("falha ao serializar o lançamento: %s", err)
+		}
+		if err := ctx.GetStub().PutState(queryResponse.Key, entryJSON); err != nil {
+			return fmt.Errorf("falha ao armazenar o lançamento: %s", err)
+		}
+		receipt.AnonymizedEntries++
+	}
+	return nil
+}
+
+// EraseVehicleData atende a um pedido de eliminação (LGPD/GDPR) da telemetria pessoal do veículo; exige o proprietário
+// ou um administrador. Apaga as amostras da coleção privada, os hashes públicos das amostras, os eventos de
+// comportamento com os seus detalhes privados e a situação do veículo nas cercas virtuais, que revelam a sua posição,
+// e as viagens com os seus resumos privados e as sessões de condução, que revelam quando e por quem o veículo foi
+// usado; a viagem e a sessão em andamento são encerradas. Apaga também os agregados diários de condução do veículo e a
+// cobertura das análises, e retira dos lançamentos de análises, na carteira do veículo e na dos motoristas das sessões,
+// as janelas, os eventos e o veículo de origem. Ficam no ledger os registros listados em ErasureReceipt.Retained e um
+// comprovante com agregados anônimos dos dados eliminados. Os valores anteriores da coleção privada só deixam os
+// peers quando o blockToLive os expurga, e os valores anteriores do world state permanecem no histórico dos blocos.
+func (s *SmartContract) EraseVehicleData(ctx contractapi.TransactionContextInterface, idcarro string) (*ErasureReceipt, error) {
+	caller, _, err := requireVehicleOwner(ctx, "EraseVehicleData", idcarro, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	receipt := &ErasureReceipt{
+		VehicleID:   idcarro,
+		CallerMSP:   caller.MSPID,
+		CallerID:    caller.ID,
+		TxID:        ctx.GetStub().GetTxID(),
+		TimeStamp:   now,
+		EventCounts: map[string]int{},
+		Retained:    erasureRetained,
+	}
+
+	if err := erasePrivateSamples(ctx, receipt); err != nil {
+		return nil, err
+	}
+
+	// hashes públicos das amostras
+	if _, err := deleteStateRange(ctx, vehicleDataKey(idcarro, 0), vehicleDataKey(idcarro, math.MaxInt64)); err != nil {
+		return nil, err
+	}
+
+	eventsJSON, err := deleteStateRange(ctx, behaviorEventKey(idcarro, 0, ""), behaviorEventKey(idcarro, math.MaxInt64, ""))
+	if err != nil {
+		return nil, err
+	}
+	for _, eventJSON := range eventsJSON {
+		var event BehaviorEvent
+		err = json.Unmarshal(eventJSON, &event)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o evento de comportamento: %s", err)
+		}
+		receipt.EventCounts[event.Type]++
+		receipt.EventCount++
+		if err := ctx.GetStub().DelPrivateData(telemetryCollection, event.key()); err != nil {
+			return nil, fmt.Errorf("falha ao eliminar o detalhe do evento de comportamento: %s", err)
+		}
+	}
+
+	if err := deleteGeofenceStates(ctx, idcarro, ""); err != nil {
+		return nil, err
+	}
+
+	tripsJSON, err := deleteStateRange(ctx, tripKey(idcarro, 0), tripKey(idcarro, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	receipt.TripCount = len(tripsJSON)
+	for _, tripJSON := range tripsJSON {
+		var trip Trip
+		err = json.Unmarshal(tripJSON, &trip)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a viagem: %s", err)
+		}
+		if err := ctx.GetStub().DelPrivateData(telemetryCollection, tripKey(idcarro, trip.StartedAt)); err != nil {
+			return nil, fmt.Errorf("falha ao eliminar o resumo privado da viagem: %s", err)
+		}
+	}
+	sessionsJSON, err := deleteStateRange(ctx, sessionKey(idcarro, 0), sessionKey(idcarro, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	receipt.SessionCount = len(sessionsJSON)
+	walletIDs := []string{idcarro}
+	seen := map[string]bool{}
+	for _, sessionJSON := range sessionsJSON {
+		var session DrivingSession
+		err = json.Unmarshal(sessionJSON, &session)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a sessão de condução: %s", err)
+		}
+		if !seen[session.DriverID] {
+			seen[session.DriverID] = true
+			walletIDs = append(walletIDs, driverWalletID(session.DriverID))
+		}
+	}
+	for _, walletID := range walletIDs {
+		if err := anonymizeCreditEntries(ctx, walletID, receipt); err != nil {
+			return nil, err
+		}
+	}
+	if err := eraseDrivingStats(ctx, receipt); err != nil {
+		return nil, err
+	}
+	for _, index := range []string{openTripIndex, activeSessionIndex} {
+		openKey, err := ctx.GetStub().CreateCompositeKey(index, []string{idcarro})
+		if err != nil {
+			return nil, fmt.Errorf("erro ao criar chave composta %s: %s", index, err)
+		}
+		if err := ctx.GetStub().DelState(openKey); err != nil {
+			return nil, fmt.Errorf("falha ao apagar o registro %s do veículo: %s", index, err)
+		}
+	}
+
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(erasureIndex, []string{idcarro, receipt.TxID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para o comprovante de eliminação: %s", err)
+	}
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao serializar o comprovante de eliminação: %s", err)
+	}
+	if err := ctx.GetStub().PutState(receiptKey, receiptJSON); err != nil {
+		return nil, fmt.Errorf("falha ao armazenar o comprovante de eliminação: %s", err)
+	}
+
+	if err := emitEvent(ctx, EventNameVehicleDataErased, receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// GetErasureReceipts consulta os comprovantes de eliminação do veículo; exige o proprietário ou um administrador
+func (s *SmartContract) GetErasureReceipts(ctx contractapi.TransactionContextInterface, idcarro string) ([]*ErasureReceipt, error) {
+	if _, _, err := requireVehicleOwner(ctx, "GetErasureReceipts", idcarro, true); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(erasureIndex, []string{idcarro})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar os comprovantes de eliminação: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	receipts := []*ErasureReceipt{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler os comprovantes de eliminação: %s", err)
+		}
+
+		var receipt ErasureReceipt
+		err = json.Unmarshal(queryResponse.Value, &receipt)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o comprovante de eliminação: %s", err)
+		}
+		receipts = append(receipts, &receipt)
+	}
+	return receipts, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Tipos de evento de comportamento produzidos pelos detectores
+const (
+	EventAnomalousAcceleration = "ANOMALOUS_ACCELERATION"
+	EventZigZag                = "ZIGZAG"
+	EventSharpTurn             = "SHARP_TURN"
+	EventSpeeding              = "SPEEDING"
+	EventGeofenceViolation     = "GEOFENCE_VIOLATION" // registrado ao armazenar as amostras, e não pela análise
+)
+
+// eventTypes define a ordem em que as detecções são avaliadas e registradas
+var eventTypes = []string{EventZigZag, EventAnomalousAcceleration, EventSharpTurn, EventSpeeding}
+
+// isBehaviorEventType informa se o tipo de evento é produzido por algum detector ou pelas cercas virtuais
+func isBehaviorEventType(eventType string) bool {
+	if eventType == EventGeofenceViolation {
+		return true
+	}
+	for _, known := range eventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Níveis de severidade de um evento, conforme o quanto o valor observado excedeu o limite
+const (
+	SeverityLow    = "LOW"
+	SeverityMedium = "MEDIUM"
+	SeverityHigh   = "HIGH"
+)
+
+// behaviorEventIndex é o prefixo da chave simples EVENT~idcarro~timestamp~tipo
+const behaviorEventIndex = "EVENT"
+
+// BehaviorEvent registra no ledger uma detecção feita sobre a telemetria do veículo. Latitude, Longitude e Measured
+// revelam a posição e a velocidade do veículo: ficam apenas na coleção privada (ver behaviorEventDetail) e são
+// preenchidos nas consultas conforme o consentimento do chamador.
+type BehaviorEvent struct {
+	DocType     string  `json:"docType"`
+	ID          string  `json:"id"`
+	VehicleID   string  `json:"vehicleId"`
+	Type        string  `json:"type"`
+	Severity    string  `json:"severity"`
+	TimeStamp   int64   `json:"timestamp"` // timestamp da amostra que disparou a detecção
+	Latitude    float64 `json:"latitude,omitempty" metadata:"latitude,optional"`
+	Longitude   float64 `json:"longitude,omitempty" metadata:"longitude,optional"`
+	Measured    float64 `json:"measured,omitempty" metadata:"measured,optional"` // valor observado
+	Threshold   float64 `json:"threshold"`                                       // limite excedido
+	CreditDelta int     `json:"creditDelta"`
+	WindowFrom  int64   `json:"windowFrom"` // janela de amostras analisada
+	WindowTo    int64   `json:"windowTo"`
+	DriverID    string  `json:"driverId,omitempty" metadata:"driverId,optional"`     // motorista da sessão que cobre a amostra
+	GeofenceID  string  `json:"geofenceId,omitempty" metadata:"geofenceId,optional"` // cerca violada, nos eventos GEOFENCE_VIOLATION
+	TxID        string  `json:"txId"`
+}
+
+// behaviorEventDetail guarda na coleção privada, sob a mesma chave do evento público, os valores que revelam a posição
+// e a velocidade do veículo. O sal impede que os valores sejam descobertos por força bruta a partir do hash que o
+// Fabric publica de cada valor privado.
+type behaviorEventDetail struct {
+	DocType   string  `json:"docType"`
+	VehicleID string  `json:"vehicleId"`
+	EventID   string  `json:"eventId"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Measured  float64 `json:"measured"`
+	Salt      string  `json:"salt"` // em hexadecimal
+}
+
+// NewBehaviorEvent cria o evento correspondente a uma detecção sobre a amostra que a disparou
+func NewBehaviorEvent(txID string, idcarro string, eventType string, detection Detection, sample VehicleData, windowFrom int64, windowTo int64) *BehaviorEvent {
+	return &BehaviorEvent{
+		DocType:     docTypeBehaviorEvent,
+		ID:          fmt.Sprintf("%s~%019d~%s", idcarro, sample.TimeStamp, eventType),
+		VehicleID:   idcarro,
+		Type:        eventType,
+		Severity:    ClassifySeverity(detection.Measured, detection.Threshold),
+		TimeStamp:   sample.TimeStamp,
+		Latitude:    sample.Latitude,
+		Longitude:   sample.Longitude,
+		Measured:    detection.Measured,
+		Threshold:   detection.Threshold,
+		CreditDelta: detection.Credits,
+		WindowFrom:  windowFrom,
+		WindowTo:    windowTo,
+		TxID:        txID,
+	}
+}
+
+// ClassifySeverity compara o valor observado com o limite: até 1,5x é baixa, até 2x é média e acima disso é alta
+func ClassifySeverity(measured float64, threshold float64) string {
+	if threshold <= 0 {
+		return SeverityHigh
+	}
+	ratio := measured / threshold
+	switch {
+	case ratio >= 2:
+		return SeverityHigh
+	case ratio >= 1.5:
+		return SeverityMedium
+	default:
+		return SeverityLow
+	}
+}
+
+// behaviorEventKey monta a chave de um evento; assim como nas amostras, o timestamp preenchido
+// com zeros permite consultar os eventos de um veículo por intervalo de tempo
+func behaviorEventKey(idcarro string, timestamp int64, eventType string) string {
+	return fmt.Sprintf("%s~%s~%019d~%s", behaviorEventIndex, idcarro, timestamp, eventType)
+}
+
+// key devolve a chave do evento; violações de cercas diferentes na mesma amostra têm chaves distintas
+func (e *BehaviorEvent) key() string {
+	eventType := e.Type
+	if e.GeofenceID != "" {
+		eventType += "~" + e.GeofenceID
+	}
+	return behaviorEventKey(e.VehicleID, e.TimeStamp, eventType)
+}
+
+// putBehaviorEvent grava o evento no ledger: a posição e o valor observado vão para a coleção privada e o registro
+// público fica sem eles. salt é o sal da amostra que disparou a detecção, do qual é derivado o sal do detalhe.
+func putBehaviorEvent(ctx contractapi.TransactionContextInterface, event *BehaviorEvent, salt []byte) error {
+	key := event.key()
+	detailJSON, err := json.Marshal(behaviorEventDetail{
+		DocType:   docTypeBehaviorEventDetail,
+		VehicleID: event.VehicleID,
+		EventID:   event.ID,
+		Latitude:  event.Latitude,
+		Longitude: event.Longitude,
+		Measured:  event.Measured,
+		Salt:      hex.EncodeToString(sampleSalt(salt, key)),
+	})
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o detalhe do evento de comportamento: %s", err)
+	}
+	err = ctx.GetStub().PutPrivateData(telemetryCollection, key, detailJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao armazenar o detalhe do evento de comportamento: %s", err)
+	}
+
+	eventJSON, err := json.Marshal(event.public())
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o evento de comportamento: %s", err)
+	}
+	err = ctx.GetStub().PutState(key, eventJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao armazenar o evento de comportamento no ledger: %s", err)
+	}
+	return nil
+}
+
+// public devolve uma cópia do evento sem a posição e o valor observado, para o world state e os eventos do chaincode
+func (e *BehaviorEvent) public() *BehaviorEvent {
+	public := *e
+	public.Latitude, public.Longitude, public.Measured = 0, 0, 0
+	return &public
+}
+
+// publicBehaviorEvents devolve as cópias públicas dos eventos, para os payloads dos eventos do chaincode
+func publicBehaviorEvents(events []*BehaviorEvent) []*BehaviorEvent {
+	public := make([]*BehaviorEvent, 0, len(events))
+	for _, event := range events {
+		public = append(public, event.public())
+	}
+	return public
+}
+
+// loadBehaviorEventDetail preenche o evento com a posição, se o chamador tem consentimento para a categoria location,
+// e com o valor observado, se tem consentimento para a categoria speed
+func loadBehaviorEventDetail(ctx contractapi.TransactionContextInterface, event *BehaviorEvent, categories map[string]bool) error {
+	if !categories[CategoryLocation] && !categories[CategorySpeed] {
+		return nil
+	}
+	detailJSON, err := ctx.GetStub().GetPrivateData(telemetryCollection, event.key())
+	if err != nil {
+		return fmt.Errorf("falha ao ler o detalhe do evento de comportamento: %s", err)
+	}
+	if detailJSON == nil {
+		return nil
+	}
+
+	var detail behaviorEventDetail
+	err = json.Unmarshal(detailJSON, &detail)
+	if err != nil {
+		return fmt.Errorf("falha ao desserializar o detalhe do evento de comportamento: %s", err)
+	}
+	if categories[CategoryLocation] {
+		event.Latitude, event.Longitude = detail.Latitude, detail.Longitude
+	}
+	if categories[CategorySpeed] {
+		event.Measured = detail.Measured
+	}
+	return nil
+}
+
+// countBehaviorEvents conta, por tipo, os eventos de comportamento do veículo com timestamp entre from e to (inclusive)
+func countBehaviorEvents(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) (map[string]int, int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(behaviorEventKey(idcarro, from, ""), behaviorEventKey(idcarro, to+1, ""))
+	if err != nil {
+		return nil, 0, fmt.Errorf("falha ao consultar os eventos do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	counts := map[string]int{}
+	total := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, 0, fmt.Errorf("falha ao iterar sobre os eventos do veículo: %s", err)
+		}
+
+		var event BehaviorEvent
+		err = json.Unmarshal(queryResponse.Value, &event)
+		if err != nil {
+			return nil, 0, fmt.Errorf("falha ao desserializar o evento de comportamento: %s", err)
+		}
+		counts[event.Type]++
+		total++
+	}
+	return counts, total, nil
+}
+
+// QueryBehaviorEvents consulta os eventos de comportamento do veículo com timestamp entre from e to (inclusive).
+// Exige consentimento para a categoria scores; a posição dos eventos exige também a categoria location
+// e o valor observado, a categoria speed.
+func (s *SmartContract) QueryBehaviorEvents(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) ([]*BehaviorEvent, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	categories, err := requireConsent(ctx, "QueryBehaviorEvents", idcarro, CategoryScores)
+	if err != nil {
+		return nil, err
+	}
+
+	// o fim do intervalo é exclusivo no GetStateByRange, por isso to+1
+	resultsIterator, err := ctx.GetStub().GetStateByRange(behaviorEventKey(idcarro, from, ""), behaviorEventKey(idcarro, to+1, ""))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar os eventos do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	events := []*BehaviorEvent{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre os eventos do veículo: %s", err)
+		}
+
+		var event BehaviorEvent
+		err = json.Unmarshal(queryResponse.Value, &event)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o evento de comportamento: %s", err)
+		}
+		if err := loadBehaviorEventDetail(ctx, &event, categories); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves compostas de frotas
+const (
+	fleetIndex       = "FLEET"       // chave composta FLEET~frota
+	fleetMemberIndex = "FLEETMEMBER" // chave composta FLEETMEMBER~placa, frota à qual o veículo pertence
+)
+
+// Fleet agrupa veículos sob a gestão de uma identidade (role=fleetmanager)
+type Fleet struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	ManagerMSP string   `json:"managerMsp"`
+	Manager    string   `json:"manager"` // identificador do gestor da frota
+	Vehicles   []string `json:"vehicles"`
+	CreatedAt  int64    `json:"createdAt"`
+	UpdatedAt  int64    `json:"updatedAt"`
+}
+
+// IsManager informa se o chamador é o gestor da frota
+func (f *Fleet) IsManager(caller *Caller) bool {
+	return f.ManagerMSP == caller.MSPID && f.Manager == caller.ID
+}
+
+// FleetVehicleSummary resume a pontuação de um veículo no relatório da frota
+type FleetVehicleSummary struct {
+	Rank          int            `json:"rank"`
+	VehicleID     string         `json:"vehicleId"`
+	Credits       int            `json:"credits"`                                             // créditos lançados pelas análises no período
+	Windows       int            `json:"windows"`                                             // janelas analisadas no período
+	DistanceKm    float64        `json:"distanceKm,omitempty" metadata:"distanceKm,optional"` // apenas com consentimento para a categoria location
+	EventCount    int            `json:"eventCount"`
+	EventCounts   map[string]int `json:"eventCounts"`
+	EventsPerHour float64        `json:"eventsPerHour"`
+}
+
+// FleetReport agrega as janelas analisadas, os créditos e os eventos de comportamento dos veículos da frota nos dias
+// (UTC) entre From e To. Veículos sem consentimento vigente da categoria scores para a organização do gestor ficam fora
+// dos agregados e são listados em Withheld. A distância não entra nos agregados da frota; ela aparece apenas no resumo
+// dos veículos com consentimento para a categoria location.
+type FleetReport struct {
+	FleetID       string                 `json:"fleetId"`
+	Name          string                 `json:"name"`
+	From          int64                  `json:"from"`
+	To            int64                  `json:"to"`
+	TotalCredits  int                    `json:"totalCredits"`
+	Windows       int                    `json:"windows"`
+	EventCount    int                    `json:"eventCount"`
+	EventCounts   map[string]int         `json:"eventCounts"`
+	EventsPerHour float64                `json:"eventsPerHour"`
+	Vehicles      []*FleetVehicleSummary `json:"vehicles"` // em ordem de classificação
+	Withheld      []string               `json:"withheld"`
+}
+
+// getFleet recupera uma frota, ou nil se ela não existir
+func getFleet(ctx contractapi.TransactionContextInterface, fleetID string) (*Fleet, error) {
+	fleetKey, err := ctx.GetStub().CreateCompositeKey(fleetIndex, []string{fleetID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a frota: %s", err)
+	}
+
+	fleetJSON, err := ctx.GetStub().GetState(fleetKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a frota: %s", err)
+	}
+	if fleetJSON == nil {
+		return nil, nil
+	}
+
+	var fleet Fleet
+	err = json.Unmarshal(fleetJSON, &fleet)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a frota: %s", err)
+	}
+	return &fleet, nil
+}
+
+// putFleet grava uma frota
+func putFleet(ctx contractapi.TransactionContextInterface, fleet *Fleet) error {
+	fleetKey, err := ctx.GetStub().CreateCompositeKey(fleetIndex, []string{fleet.ID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a frota: %s", err)
+	}
+
+	fleetJSON, err := json.Marshal(fleet)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a frota: %s", err)
+	}
+
+	return ctx.GetStub().PutState(fleetKey, fleetJSON)
+}
+
+// fleetMemberKey monta a chave que registra a frota de um veículo
+func fleetMemberKey(ctx contractapi.TransactionContextInterface, idcarro string) (string, error) {
+	memberKey, err := ctx.GetStub().CreateCompositeKey(fleetMemberIndex, []string{idcarro})
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar chave composta para o membro da frota: %s", err)
+	}
+	return memberKey, nil
+}
+
+// getVehicleFleet devolve a frota à qual o veículo pertence, ou vazio se ele não pertencer a nenhuma
+func getVehicleFleet(ctx contractapi.TransactionContextInterface, idcarro string) (string, error) {
+	memberKey, err := fleetMemberKey(ctx, idcarro)
+	if err != nil {
+		return "", err
+	}
+	fleetID, err := ctx.GetStub().GetState(memberKey)
+	if err != nil {
+		return "", fmt.Errorf("falha ao ler a frota do veículo: %s", err)
+	}
+	return string(fleetID), nil
+}
+
+// requireFleetManager garante que o chamador é o gestor da frota (ou um administrador, se allowAdmin) e devolve a frota
+func requireFleetManager(ctx contractapi.TransactionContextInterface, operation string, fleetID string, allowAdmin bool) (*Caller, *Fleet, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	fleet, err := getFleet(ctx, fleetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fleet == nil {
+		return nil, nil, fmt.Errorf("frota %s não encontrada", fleetID)
+	}
+	if fleet.IsManager(caller) || (allowAdmin && caller.HasRole(RoleAdmin)) {
+		return caller, fleet, nil
+	}
+	return nil, nil, permissionDenied(operation, caller, "exige o gestor da frota "+fleetID)
+}
+
+// CreateFleet cria uma frota vazia tendo o chamador (role=fleetmanager) como gestor
+func (s *SmartContract) CreateFleet(ctx contractapi.TransactionContextInterface, fleetID string, name string) error {
+	caller, err := requireRole(ctx, "CreateFleet", RoleFleetManager)
+	if err != nil {
+		return err
+	}
+	if fleetID == "" || name == "" {
+		return fmt.Errorf("identificador e nome da frota são obrigatórios")
+	}
+	existing, err := getFleet(ctx, fleetID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("frota %s já existe", fleetID)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putFleet(ctx, &Fleet{
+		ID:         fleetID,
+		Name:       name,
+		ManagerMSP: caller.MSPID,
+		Manager:    caller.ID,
+		Vehicles:   []string{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+}
+
+// GetFleet consulta uma frota; exige o gestor da frota ou um administrador
+func (s *SmartContract) GetFleet(ctx contractapi.TransactionContextInterface, fleetID string) (*Fleet, error) {
+	_, fleet, err := requireFleetManager(ctx, "GetFleet", fleetID, true)
+	if err != nil {
+		return nil, err
+	}
+	return fleet, nil
+}
+
+// AddVehicleToFleet inclui um veículo na frota; exige o gestor da frota. O veículo não pode pertencer a outra frota
+// e o gestor precisa ser o seu proprietário ou ter consentimento vigente da categoria scores.
+func (s *SmartContract) AddVehicleToFleet(ctx contractapi.TransactionContextInterface, fleetID string, idcarro string) error {
+	caller, fleet, err := requireFleetManager(ctx, "AddVehicleToFleet", fleetID, false)
+	if err != nil {
+		return err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	if vehicle == nil {
+		return fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+	categories, err := consentedCategories(ctx, caller, vehicle)
+	if err != nil {
+		return err
+	}
+	if !categories[CategoryScores] {
+		return permissionDenied("AddVehicleToFleet", caller, fmt.Sprintf("sem consentimento do proprietário para a categoria %s do veículo %s", CategoryScores, idcarro))
+	}
+
+	current, err := getVehicleFleet(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	if current != "" {
+		return fmt.Errorf("veículo %s já pertence à frota %s", idcarro, current)
+	}
+
+	fleet.Vehicles = append(fleet.Vehicles, idcarro)
+	fleet.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if err := putFleet(ctx, fleet); err != nil {
+		return err
+	}
+
+	memberKey, err := fleetMemberKey(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(memberKey, []byte(fleetID))
+}
+
+// RemoveVehicleFromFleet retira um veículo da frota; exige o gestor da frota, um administrador ou o proprietário do veículo
+func (s *SmartContract) RemoveVehicleFromFleet(ctx contractapi.TransactionContextInterface, fleetID string, idcarro string) error {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return err
+	}
+	fleet, err := getFleet(ctx, fleetID)
+	if err != nil {
+		return err
+	}
+	if fleet == nil {
+		return fmt.Errorf("frota %s não encontrada", fleetID)
+	}
+	if !fleet.IsManager(caller) && !caller.HasRole(RoleAdmin) {
+		if _, _, err := requireVehicleOwner(ctx, "RemoveVehicleFromFleet", idcarro, false); err != nil {
+			return permissionDenied("RemoveVehicleFromFleet", caller, "exige o gestor da frota "+fleetID+" ou o proprietário do veículo "+idcarro)
+		}
+	}
+
+	vehicles := []string{}
+	for _, member := range fleet.Vehicles {
+		if member != idcarro {
+			vehicles = append(vehicles, member)
+		}
+	}
+	if len(vehicles) == len(fleet.Vehicles) {
+		return fmt.Errorf("veículo %s não pertence à frota %s", idcarro, fleetID)
+	}
+
+	fleet.Vehicles = vehicles
+	fleet.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if err := putFleet(ctx, fleet); err != nil {
+		return err
+	}
+
+	// a situação nas cercas da frota deixa de valer fora dela
+	if err := deleteGeofenceStates(ctx, idcarro, ""); err != nil {
+		return err
+	}
+
+	memberKey, err := fleetMemberKey(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(memberKey)
+}
+
+// GetFleetReport agrega a pontuação de condução (ver GetDrivingScore) dos veículos da frota entre from e to e os
+// classifica por eventos por hora de condução analisada, com mais créditos como desempate; veículos sem janelas
+// analisadas no período ficam por último. Exige o gestor da frota ou um administrador.
+func (s *SmartContract) GetFleetReport(ctx contractapi.TransactionContextInterface, fleetID string, from int64, to int64) (*FleetReport, error) {
+	caller, fleet, err := requireFleetManager(ctx, "GetFleetReport", fleetID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FleetReport{
+		FleetID:     fleet.ID,
+		Name:        fleet.Name,
+		From:        from,
+		To:          to,
+		EventCounts: map[string]int{},
+		Vehicles:    []*FleetVehicleSummary{},
+		Withheld:    []string{},
+	}
+	for _, idcarro := range fleet.Vehicles {
+		vehicle, err := getVehicle(ctx, idcarro)
+		if err != nil {
+			return nil, err
+		}
+		if vehicle == nil {
+			return nil, fmt.Errorf("veículo %s não registrado", idcarro)
+		}
+		categories, err := consentedCategories(ctx, caller, vehicle)
+		if err != nil {
+			return nil, err
+		}
+		if !categories[CategoryScores] {
+			report.Withheld = append(report.Withheld, idcarro)
+			continue
+		}
+
+		score, err := getDrivingScore(ctx, idcarro, from, to, categories[CategoryLocation])
+		if err != nil {
+			return nil, err
+		}
+		summary := &FleetVehicleSummary{
+			VehicleID:     idcarro,
+			Credits:       score.Credits,
+			Windows:       score.Windows,
+			DistanceKm:    score.DistanceKm,
+			EventCount:    score.EventCount,
+			EventCounts:   score.EventCounts,
+			EventsPerHour: score.EventsPerHour,
+		}
+
+		report.TotalCredits += summary.Credits
+		report.Windows += summary.Windows
+		report.EventCount += summary.EventCount
+		for eventType, count := range summary.EventCounts {
+			report.EventCounts[eventType] += count
+		}
+		report.Vehicles = append(report.Vehicles, summary)
+	}
+	if report.Windows > 0 {
+		report.EventsPerHour = float64(report.EventCount) / (float64(report.Windows) * analysisWindow / hourMillis)
+	}
+
+	sort.SliceStable(report.Vehicles, func(i, j int) bool {
+		a, b := report.Vehicles[i], report.Vehicles[j]
+		if (a.Windows > 0) != (b.Windows > 0) {
+			return a.Windows > 0
+		}
+		if a.EventsPerHour != b.EventsPerHour {
+			return a.EventsPerHour < b.EventsPerHour
+		}
+		if a.Credits != b.Credits {
+			return a.Credits > b.Credits
+		}
+		return a.VehicleID < b.VehicleID
+	})
+	for i, summary := range report.Vehicles {
+		summary.Rank = i + 1
+	}
+	return report, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// GeoPoint é uma coordenada geográfica em graus decimais
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Validate verifica se a coordenada está dentro das faixas de latitude e longitude
+func (p GeoPoint) Validate() error {
+	// a comparação negada também rejeita NaN
+	if !(p.Latitude >= -90 && p.Latitude <= 90) || !(p.Longitude >= -180 && p.Longitude <= 180) {
+		return fmt.Errorf("coordenada fora do intervalo: (%v, %v)", p.Latitude, p.Longitude)
+	}
+	return nil
+}
+
+// validateGeoPoints verifica se a lista tem ao menos min coordenadas válidas
+func validateGeoPoints(name string, points []GeoPoint, min int) error {
+	if len(points) < min {
+		return fmt.Errorf("%s deve ter ao menos %d pontos", name, min)
+	}
+	for _, point := range points {
+		if err := point.Validate(); err != nil {
+			return fmt.Errorf("%s inválido: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// PointInPolygon informa se o ponto está dentro do polígono (vértices em ordem, sem repetir o primeiro),
+// pelo método do raio. Latitude e longitude são tratadas como coordenadas planas, o que é adequado para áreas
+// urbanas, mas não para polígonos que cruzam o antimeridiano.
+func PointInPolygon(point GeoPoint, polygon []GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Latitude > point.Latitude) != (b.Latitude > point.Latitude) &&
+			point.Longitude < (b.Longitude-a.Longitude)*(point.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// DistanceToPath calcula, em quilômetros, a menor distância entre o ponto e a linha formada pelos segmentos do trajeto.
+// Os segmentos são projetados num plano tangente ao ponto, o que é preciso para distâncias de poucos quilômetros.
+func DistanceToPath(point GeoPoint, path []GeoPoint) float64 {
+	// projeção equirretangular centrada no ponto, em quilômetros
+	project := func(p GeoPoint) (float64, float64) {
+		x := (p.Longitude - point.Longitude) * math.Pi / 180 * math.Cos(point.Latitude*math.Pi/180) * earthRadiusKm
+		y := (p.Latitude - point.Latitude) * math.Pi / 180 * earthRadiusKm
+		return x, y
+	}
+
+	distance := math.Inf(1)
+	for i := 1; i < len(path); i++ {
+		ax, ay := project(path[i-1])
+		bx, by := project(path[i])
+		dx, dy := bx-ax, by-ay
+
+		// posição, ao longo do segmento, do ponto mais próximo da origem (o próprio ponto)
+		t := 0.0
+		if length := dx*dx + dy*dy; length > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+		}
+		distance = math.Min(distance, math.Hypot(ax+t*dx, ay+t*dy))
+	}
+	return distance
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestPointInPolygon(t *testing.T) {
+	square := []GeoPoint{{0, 0}, {0, 1}, {1, 1}, {1, 0}}
+	// polígono em L: o quadrante superior direito fica de fora
+	lShape := []GeoPoint{{0, 0}, {0, 2}, {1, 2}, {1, 1}, {2, 1}, {2, 0}}
+
+	tests := []struct {
+		name    string
+		point   GeoPoint
+		polygon []GeoPoint
+		want    bool
+	}{
+		{"centro do quadrado", GeoPoint{0.5, 0.5}, square, true},
+		{"acima do quadrado", GeoPoint{1.5, 0.5}, square, false},
+		{"à esquerda do quadrado", GeoPoint{0.5, -0.5}, square, false},
+		{"na altura de um vértice, fora", GeoPoint{1, 1.5}, square, false},
+		{"braço do L", GeoPoint{1.5, 0.5}, lShape, true},
+		{"reentrância do L", GeoPoint{1.5, 1.5}, lShape, false},
+		{"polígono vazio", GeoPoint{0.5, 0.5}, nil, false},
+		{"coordenadas negativas", GeoPoint{-22.95, -43.25}, []GeoPoint{{-23, -43.3}, {-23, -43.2}, {-22.9, -43.2}, {-22.9, -43.3}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PointInPolygon(tt.point, tt.polygon); got != tt.want {
+				t.Errorf("PointInPolygon(%v) = %v, esperado %v", tt.point, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves compostas de cercas virtuais
+const (
+	geofenceIndex      = "GEOFENCE"      // chave composta GEOFENCE~frota~cerca
+	geofenceStateIndex = "GEOFENCESTATE" // chave composta GEOFENCESTATE~placa~cerca, presente enquanto o veículo viola a cerca
+)
+
+// minGeofencePenalty é a menor penalidade aceita para uma cerca, em créditos por violação
+const minGeofencePenalty = -1000
+
+// Ações das regras de cercas virtuais
+const (
+	GeofenceForbidEntry = "FORBID_ENTRY" // viola a regra estar dentro da área, como uma zona escolar no horário de aula
+	GeofenceForbidExit  = "FORBID_EXIT"  // viola a regra estar fora da área, como a região da rota contratada
+)
+
+// GeofenceCircle é uma área circular
+type GeofenceCircle struct {
+	Center GeoPoint `json:"center"`
+	Radius float64  `json:"radius"` // em metros
+}
+
+// TimeWindow é um período recorrente em que a regra de uma cerca vale, no horário local da cerca
+type TimeWindow struct {
+	Days  []int  `json:"days,omitempty" metadata:"days,optional"` // dias da semana (0 = domingo) em que a janela começa; vazio vale para todos
+	Start string `json:"start"`                                   // HH:MM
+	End   string `json:"end"`                                     // HH:MM; se não for posterior a Start, a janela atravessa a meia-noite
+}
+
+// Geofence é uma cerca virtual definida pelo gestor da frota e avaliada sobre cada amostra armazenada dos veículos da frota.
+// Cada vez que um veículo passa a violar a regra, é registrado um evento GEOFENCE_VIOLATION com a penalidade da cerca.
+type Geofence struct {
+	ID          string          `json:"id"`
+	FleetID     string          `json:"fleetId"`
+	Name        string          `json:"name"`
+	Circle      *GeofenceCircle `json:"circle,omitempty" metadata:"circle,optional"`
+	Polygon     []GeoPoint      `json:"polygon,omitempty" metadata:"polygon,optional"` // vértices da área, sem repetir o primeiro
+	Action      string          `json:"action"`
+	TimeWindows []TimeWindow    `json:"timeWindows,omitempty" metadata:"timeWindows,optional"` // vazio: a regra vale sempre
+	UTCOffset   int             `json:"utcOffset"`                                             // minutos do horário local em relação ao UTC, como -180 em Brasília
+	Penalty     int             `json:"penalty"`                                               // créditos, de minGeofencePenalty a 0; zero apenas registra o evento
+	UpdatedBy   string          `json:"updatedBy"`
+	UpdatedAt   int64           `json:"updatedAt"`
+}
+
+// parseClock converte um horário HH:MM em minutos desde a meia-noite
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("horário inválido %q: use HH:MM", clock)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Validate verifica a geometria, a ação, as janelas e a penalidade da cerca
+func (g *Geofence) Validate() error {
+	if g.ID == "" || g.Name == "" {
+		return fmt.Errorf("identificador e nome da cerca são obrigatórios")
+	}
+	switch {
+	case g.Circle != nil && len(g.Polygon) > 0:
+		return fmt.Errorf("a cerca deve ter circle ou polygon, não ambos")
+	case g.Circle != nil:
+		if err := g.Circle.Center.Validate(); err != nil {
+			return fmt.Errorf("centro inválido: %s", err)
+		}
+		if !(g.Circle.Radius > 0) || math.IsInf(g.Circle.Radius, 1) {
+			return fmt.Errorf("raio da cerca deve ser positivo")
+		}
+	case len(g.Polygon) > 0:
+		if err := validateGeoPoints("polygon", g.Polygon, 3); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("a cerca deve ter circle ou polygon")
+	}
+	if g.Action != GeofenceForbidEntry && g.Action != GeofenceForbidExit {
+		return fmt.Errorf("ação desconhecida %q: use %s ou %s", g.Action, GeofenceForbidEntry, GeofenceForbidExit)
+	}
+	for _, window := range g.TimeWindows {
+		if _, err := parseClock(window.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(window.End); err != nil {
+			return err
+		}
+		for _, day := range window.Days {
+			if day < 0 || day > 6 {
+				return fmt.Errorf("dia da semana inválido %d: use de 0 (domingo) a 6", day)
+			}
+		}
+	}
+	if g.UTCOffset < -14*60 || g.UTCOffset > 14*60 {
+		return fmt.Errorf("utcOffset inválido %d: use de -840 a 840 minutos", g.UTCOffset)
+	}
+	if g.Penalty > 0 || g.Penalty < minGeofencePenalty {
+		return fmt.Errorf("penalidade da cerca inválida %d: use de %d a 0 créditos", g.Penalty, minGeofencePenalty)
+	}
+	return nil
+}
+
+// Contains informa se o ponto está dentro da área da cerca
+func (g *Geofence) Contains(point GeoPoint) bool {
+	if g.Circle != nil {
+		return Haversine(g.Circle.Center.Latitude, g.Circle.Center.Longitude, point.Latitude, point.Longitude)*1000 <= g.Circle.Radius
+	}
+	return PointInPolygon(point, g.Polygon)
+}
+
+// ActiveAt informa se a regra da cerca vale no timestamp (ms) informado
+func (g *Geofence) ActiveAt(timestamp int64) bool {
+	if len(g.TimeWindows) == 0 {
+		return true
+	}
+
+	local := time.UnixMilli(timestamp).UTC().Add(time.Duration(g.UTCOffset) * time.Minute)
+	minute := local.Hour()*60 + local.Minute()
+	today := int(local.Weekday())
+	yesterday := (today + 6) % 7
+	for _, window := range g.TimeWindows {
+		// horários já validados em PutGeofence
+		start, _ := parseClock(window.Start)
+		end, _ := parseClock(window.End)
+		if start < end {
+			if minute >= start && minute < end && window.coversDay(today) {
+				return true
+			}
+			continue
+		}
+		// janela que atravessa a meia-noite: depois da meia-noite, vale o dia em que a janela começou
+		if (minute >= start && window.coversDay(today)) || (minute < end && window.coversDay(yesterday)) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversDay informa se a janela começa no dia da semana informado
+func (w TimeWindow) coversDay(day int) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Violated informa se a amostra viola a regra da cerca
+func (g *Geofence) Violated(sample *VehicleData) bool {
+	if !g.ActiveAt(sample.TimeStamp) {
+		return false
+	}
+	inside := g.Contains(GeoPoint{Latitude: sample.Latitude, Longitude: sample.Longitude})
+	return inside == (g.Action == GeofenceForbidEntry)
+}
+
+// geofenceKey monta a chave de uma cerca da frota
+func geofenceKey(ctx contractapi.TransactionContextInterface, fleetID string, geofenceID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(geofenceIndex, []string{fleetID, geofenceID})
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar chave composta para a cerca: %s", err)
+	}
+	return key, nil
+}
+
+// listFleetGeofences recupera as cercas da frota
+func listFleetGeofences(ctx contractapi.TransactionContextInterface, fleetID string) ([]*Geofence, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(geofenceIndex, []string{fleetID})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as cercas da frota: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	geofences := []*Geofence{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler as cercas da frota: %s", err)
+		}
+
+		var geofence Geofence
+		err = json.Unmarshal(queryResponse.Value, &geofence)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a cerca: %s", err)
+		}
+		geofences = append(geofences, &geofence)
+	}
+	return geofences, nil
+}
+
+// evaluateGeofences avalia as amostras recém-armazenadas, em ordem cronológica, contra as cercas da frota do veículo.
+// Um evento é registrado quando o veículo passa a violar uma cerca; enquanto a violação continuar, novas amostras não
+// geram eventos. As penalidades vão para a carteira do motorista, se uma sessão cobrir a amostra, ou para a do veículo.
+// salt é o sal da transação, do qual foram derivados os sais das amostras.
+func evaluateGeofences(ctx contractapi.TransactionContextInterface, idcarro string, samples []*VehicleData, salt []byte) error {
+	fleetID, err := getVehicleFleet(ctx, idcarro)
+	if err != nil || fleetID == "" {
+		return err
+	}
+	geofences, err := listFleetGeofences(ctx, fleetID)
+	if err != nil || len(geofences) == 0 {
+		return err
+	}
+
+	from, to := samples[0].TimeStamp, samples[len(samples)-1].TimeStamp
+	sessions, err := getSessionsStartedBetween(ctx, idcarro, from-maxSessionDuration, to)
+	if err != nil {
+		return err
+	}
+
+	events := []*BehaviorEvent{}
+	entries := []*CreditEntry{}
+	driverEntries := map[string][]*CreditEntry{}
+	deltas := map[string]*DrivingStats{} // por carteira e dia
+	addDelta := func(walletID string, timestamp int64, credits int) {
+		key := fmt.Sprintf("%s~%d", walletID, dayOf(timestamp))
+		if deltas[key] == nil {
+			deltas[key] = newDrivingStats(walletID, dayOf(timestamp))
+		}
+		deltas[key].EventCount++
+		deltas[key].EventCounts[EventGeofenceViolation]++
+		deltas[key].Credits += credits
+	}
+
+	saldo := 0
+	for _, geofence := range geofences {
+		stateKey, err := ctx.GetStub().CreateCompositeKey(geofenceStateIndex, []string{idcarro, geofence.ID})
+		if err != nil {
+			return fmt.Errorf("erro ao criar chave composta para a situação da cerca: %s", err)
+		}
+		state, err := ctx.GetStub().GetState(stateKey)
+		if err != nil {
+			return fmt.Errorf("falha ao ler a situação da cerca: %s", err)
+		}
+		wasViolating := state != nil
+		violating := wasViolating
+
+		for _, sample := range samples {
+			violated := geofence.Violated(sample)
+			if violated && !violating {
+				detection := Detection{Detected: true, Credits: geofence.Penalty}
+				event := NewBehaviorEvent(ctx.GetStub().GetTxID(), idcarro, EventGeofenceViolation, detection, *sample, from, to)
+				event.ID += "~" + geofence.ID
+				event.GeofenceID = geofence.ID
+				event.DriverID = driverAt(sessions, sample.TimeStamp)
+				if err := putBehaviorEvent(ctx, event, sampleSalt(salt, vehicleDataKey(idcarro, sample.TimeStamp))); err != nil {
+					return err
+				}
+				events = append(events, event)
+				saldo += geofence.Penalty
+
+				entry := CreditEntry{
+					Amount:     geofence.Penalty,
+					Reason:     ReasonBehaviorEvent,
+					EventID:    event.ID,
+					Reference:  EventGeofenceViolation,
+					WindowFrom: from,
+					WindowTo:   to,
+				}
+				addDelta(idcarro, sample.TimeStamp, entry.Amount)
+				if event.DriverID != "" {
+					entry.VehicleID = idcarro
+					driverEntries[event.DriverID] = append(driverEntries[event.DriverID], &entry)
+					addDelta(driverWalletID(event.DriverID), sample.TimeStamp, entry.Amount)
+				} else {
+					entries = append(entries, &entry)
+				}
+			}
+			violating = violated
+		}
+
+		switch {
+		case violating && !wasViolating:
+			err = ctx.GetStub().PutState(stateKey, []byte(fleetID))
+		case !violating && wasViolating:
+			err = ctx.GetStub().DelState(stateKey)
+		}
+		if err != nil {
+			return fmt.Errorf("falha ao armazenar a situação da cerca: %s", err)
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	vehicleWallet, err := postCreditEntries(ctx, idcarro, entries)
+	if err != nil {
+		return err
+	}
+	if err := postDriverCreditEntries(ctx, driverEntries); err != nil {
+		return err
+	}
+	if err := addOpenTripCredits(ctx, idcarro, from, saldo); err != nil {
+		return err
+	}
+	deltaKeys := make([]string, 0, len(deltas))
+	for key := range deltas {
+		deltaKeys = append(deltaKeys, key)
+	}
+	sort.Strings(deltaKeys)
+	for _, key := range deltaKeys {
+		if err := addDrivingStats(ctx, deltas[key].WalletID, deltas[key].Day, deltas[key], nil); err != nil {
+			return err
+		}
+	}
+
+	return emitEvent(ctx, EventNameBehaviorDetected, BehaviorDetectedPayload{
+		VehicleID:   idcarro,
+		Events:      publicBehaviorEvents(events),
+		CreditDelta: saldo,
+		Balance:     vehicleWallet.Credits,
+	})
+}
+
+// PutGeofence cria ou substitui uma cerca virtual da frota; exige o gestor da frota ou um administrador.
+// geofenceJSON contém id, name, action, penalty, circle (center e radius em metros) ou polygon e, opcionalmente,
+// timeWindows e utcOffset. Ao substituir uma cerca, a situação dos veículos da frota na cerca anterior é apagada,
+// e a violação da nova regra é registrada na próxima amostra que a violar.
+func (s *SmartContract) PutGeofence(ctx contractapi.TransactionContextInterface, fleetID string, geofenceJSON string) error {
+	caller, fleet, err := requireFleetManager(ctx, "PutGeofence", fleetID, true)
+	if err != nil {
+		return err
+	}
+
+	var geofence Geofence
+	decoder := json.NewDecoder(strings.NewReader(geofenceJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&geofence); err != nil {
+		return fmt.Errorf("cerca inválida: %s", err)
+	}
+	if err := geofence.Validate(); err != nil {
+		return err
+	}
+
+	geofence.FleetID = fleetID
+	geofence.UpdatedBy = caller.ID
+	geofence.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := geofenceKey(ctx, fleetID, geofence.ID)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("falha ao ler a cerca: %s", err)
+	}
+	if existing != nil {
+		for _, idcarro := range fleet.Vehicles {
+			if err := deleteGeofenceStates(ctx, idcarro, geofence.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	geofenceBytes, err := json.Marshal(geofence)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a cerca: %s", err)
+	}
+	return ctx.GetStub().PutState(key, geofenceBytes)
+}
+
+// deleteGeofenceStates apaga a situação do veículo nas cercas: apenas na cerca informada ou, se geofenceID for vazio,
+// em todas as cercas
+func deleteGeofenceStates(ctx contractapi.TransactionContextInterface, idcarro string, geofenceID string) error {
+	attributes := []string{idcarro}
+	if geofenceID != "" {
+		attributes = append(attributes, geofenceID)
+	}
+	statesIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(geofenceStateIndex, attributes)
+	if err != nil {
+		return fmt.Errorf("falha ao consultar a situação do veículo nas cercas: %s", err)
+	}
+	defer statesIterator.Close()
+
+	for statesIterator.HasNext() {
+		queryResponse, err := statesIterator.Next()
+		if err != nil {
+			return fmt.Errorf("falha ao ler a situação do veículo nas cercas: %s", err)
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return fmt.Errorf("falha ao apagar a situação do veículo nas cercas: %s", err)
+		}
+	}
+	return nil
+}
+
+// DeleteGeofence remove uma cerca virtual da frota e a situação dos veículos da frota nela;
+// exige o gestor da frota ou um administrador
+func (s *SmartContract) DeleteGeofence(ctx contractapi.TransactionContextInterface, fleetID string, geofenceID string) error {
+	_, fleet, err := requireFleetManager(ctx, "DeleteGeofence", fleetID, true)
+	if err != nil {
+		return err
+	}
+
+	key, err := geofenceKey(ctx, fleetID, geofenceID)
+	if err != nil {
+		return err
+	}
+	geofenceJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("falha ao ler a cerca: %s", err)
+	}
+	if geofenceJSON == nil {
+		return fmt.Errorf("cerca %s não encontrada na frota %s", geofenceID, fleetID)
+	}
+	for _, idcarro := range fleet.Vehicles {
+		if err := deleteGeofenceStates(ctx, idcarro, geofenceID); err != nil {
+			return err
+		}
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// ListFleetGeofences consulta as cercas virtuais da frota; exige o gestor da frota ou um administrador
+func (s *SmartContract) ListFleetGeofences(ctx contractapi.TransactionContextInterface, fleetID string) ([]*Geofence, error) {
+	if _, _, err := requireFleetManager(ctx, "ListFleetGeofences", fleetID, true); err != nil {
+		return nil, err
+	}
+	return listFleetGeofences(ctx, fleetID)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeofenceActiveAt(t *testing.T) {
+	// horário de Brasília; 2024-01-01 foi uma segunda-feira
+	at := func(day int, hour int, minute int) int64 {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC).Add(3 * time.Hour).UnixMilli()
+	}
+	school := []TimeWindow{{Days: []int{1, 2, 3, 4, 5}, Start: "07:00", End: "12:00"}}
+	overnight := []TimeWindow{{Days: []int{5}, Start: "22:00", End: "06:00"}}
+
+	tests := []struct {
+		name      string
+		windows   []TimeWindow
+		timestamp int64
+		want      bool
+	}{
+		{"sem janelas", nil, at(7, 3, 0), true},
+		{"início da janela", school, at(1, 7, 0), true},
+		{"antes do início", school, at(1, 6, 59), false},
+		{"fim da janela é exclusivo", school, at(1, 12, 0), false},
+		{"último minuto da janela", school, at(5, 11, 59), true},
+		{"dia fora da janela", school, at(7, 8, 0), false},
+		{"noturna no dia de início", overnight, at(5, 23, 0), true},
+		{"noturna depois da meia-noite", overnight, at(6, 5, 59), true},
+		{"noturna no fim", overnight, at(6, 6, 0), false},
+		{"noturna depois da meia-noite do dia de início", overnight, at(5, 2, 0), false},
+		{"janela sem dias vale todos os dias", []TimeWindow{{Start: "00:00", End: "00:30"}}, at(7, 0, 15), true},
+		// 2024-01-01 02:00 UTC ainda é domingo, 23:00, no horário local
+		{"dia local difere do dia UTC", school, time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC).UnixMilli(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			geofence := &Geofence{TimeWindows: tt.windows, UTCOffset: -180}
+			if got := geofence.ActiveAt(tt.timestamp); got != tt.want {
+				t.Errorf("ActiveAt(%d) = %v, esperado %v", tt.timestamp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeofenceValidate(t *testing.T) {
+	valid := func() *Geofence {
+		return &Geofence{
+			ID:      "escola",
+			Name:    "Zona escolar",
+			Circle:  &GeofenceCircle{Center: GeoPoint{-22.9, -43.2}, Radius: 300},
+			Action:  GeofenceForbidEntry,
+			Penalty: -20,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(g *Geofence)
+		wantErr bool
+	}{
+		{"cerca válida", func(g *Geofence) {}, false},
+		{"penalidade zero", func(g *Geofence) { g.Penalty = 0 }, false},
+		{"penalidade mínima", func(g *Geofence) { g.Penalty = minGeofencePenalty }, false},
+		{"penalidade abaixo da mínima", func(g *Geofence) { g.Penalty = minGeofencePenalty - 1 }, true},
+		{"penalidade positiva", func(g *Geofence) { g.Penalty = 1 }, true},
+		{"sem geometria", func(g *Geofence) { g.Circle = nil }, true},
+		{"círculo e polígono", func(g *Geofence) { g.Polygon = []GeoPoint{{0, 0}, {0, 1}, {1, 1}} }, true},
+		{"raio zero", func(g *Geofence) { g.Circle.Radius = 0 }, true},
+		{"polígono com dois pontos", func(g *Geofence) { g.Circle, g.Polygon = nil, []GeoPoint{{0, 0}, {0, 1}} }, true},
+		{"ação desconhecida", func(g *Geofence) { g.Action = "FORBID" }, true},
+		{"horário inválido", func(g *Geofence) { g.TimeWindows = []TimeWindow{{Start: "24:00", End: "06:00"}} }, true},
+		{"dia da semana inválido", func(g *Geofence) { g.TimeWindows = []TimeWindow{{Days: []int{7}, Start: "07:00", End: "12:00"}} }, true},
+		{"utcOffset no limite", func(g *Geofence) { g.UTCOffset = -14 * 60 }, false},
+		{"utcOffset fora do limite", func(g *Geofence) { g.UTCOffset = 14*60 + 1 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			geofence := valid()
+			tt.modify(geofence)
+			if err := geofence.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() erro = %v, esperado erro: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxHistoryPageSize limita o número de amostras devolvidas por página do histórico
+const maxHistoryPageSize = 500
+
+// VehicleHistory é uma página do histórico de telemetria do veículo
+type VehicleHistory struct {
+	VehicleID           string        `json:"vehicleId"`
+	Samples             []VehicleData `json:"samples"`
+	FetchedRecordsCount int32         `json:"fetchedRecordsCount"`
+	Bookmark            string        `json:"bookmark"` // vazio quando não há mais páginas
+}
+
+// GetVehicleHistory consulta, em ordem cronológica e em páginas, as leituras do veículo entre from e to
+// (inclusive, em milissegundos). Para a próxima página, repita a consulta com o bookmark devolvido.
+// Exige o proprietário, um administrador ou uma compra de dados válida para o intervalo; campos sem
+// consentimento do proprietário para a organização do chamador são removidos.
+//
+// A coleção privada não oferece consulta paginada, por isso a paginação percorre os hashes públicos das
+// amostras, que usam as mesmas chaves, e cada amostra é lida da coleção privada.
+func (s *SmartContract) GetVehicleHistory(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64, pageSize int32, bookmark string) (*VehicleHistory, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if pageSize < 1 || pageSize > maxHistoryPageSize {
+		return nil, fmt.Errorf("tamanho de página inválido %d: use de 1 a %d", pageSize, maxHistoryPageSize)
+	}
+	categories, err := requireDataAccess(ctx, "GetVehicleHistory", idcarro, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// o fim do intervalo é exclusivo no GetStateByRange, por isso to+1
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(vehicleDataKey(idcarro, from), vehicleDataKey(idcarro, to+1), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar o histórico do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	history := &VehicleHistory{
+		VehicleID: idcarro,
+		Samples:   []VehicleData{},
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre o histórico do veículo: %s", err)
+		}
+
+		vehicleDataJSON, err := ctx.GetStub().GetPrivateData(telemetryCollection, queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler os dados privados do veículo: %s", err)
+		}
+		if vehicleDataJSON == nil {
+			continue
+		}
+
+		var vehicleData VehicleData
+		err = json.Unmarshal(vehicleDataJSON, &vehicleData)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+		}
+		vehicleData.Redact(categories)
+		history.Samples = append(history.Samples, vehicleData)
+	}
+
+	history.FetchedRecordsCount = metadata.GetFetchedRecordsCount()
+	history.Bookmark = metadata.GetBookmark()
+	return history, nil
+}
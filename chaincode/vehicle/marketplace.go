@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves compostas do mercado de dados
+const (
+	dataOfferIndex    = "DATAOFFER" // chave composta DATAOFFER~placa
+	dataPurchaseIndex = "PURCHASE"  // chave composta PURCHASE~placa~MSP comprador~transação
+)
+
+const (
+	hourMillis = 60 * 60 * 1000
+	// maxPurchaseRange limita o intervalo de dados coberto por uma compra
+	maxPurchaseRange = 366 * 24 * hourMillis
+	// maxTelemetryReadWindow limita o intervalo devolvido por GetVehicleData em uma chamada
+	maxTelemetryReadWindow = hourMillis
+	// maxPricePerHour limita o preço por hora de uma oferta, em créditos
+	maxPricePerHour = 1000000
+	// maxAccessDuration limita a validade de cada compra
+	maxAccessDuration = 366 * 24 * hourMillis
+)
+
+// DataOffer é a oferta dos dados de telemetria de um veículo, com preço definido pelo proprietário
+type DataOffer struct {
+	VehicleID      string `json:"vehicleId"`
+	PricePerHour   int    `json:"pricePerHour"`   // créditos por hora de dados comprada
+	AccessDuration int64  `json:"accessDuration"` // validade de cada compra, em milissegundos
+	Active         bool   `json:"active"`
+	UpdatedAt      int64  `json:"updatedAt"`
+}
+
+// DataPurchase dá à organização compradora acesso à telemetria do veículo entre From e To até ExpiresAt
+type DataPurchase struct {
+	ID          string `json:"id"` // identificador da transação de compra
+	VehicleID   string `json:"vehicleId"`
+	BuyerMSP    string `json:"buyerMsp"`
+	BuyerID     string `json:"buyerId"`
+	From        int64  `json:"from"`
+	To          int64  `json:"to"`
+	Price       int    `json:"price"`
+	PurchasedAt int64  `json:"purchasedAt"`
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+// PurchasePrice calcula o preço de um intervalo de dados, cobrando cada hora iniciada.
+// Devolve erro se o preço não for positivo ou não couber em um int.
+func (o *DataOffer) PurchasePrice(from int64, to int64) (int, error) {
+	hours := (to - from + hourMillis) / hourMillis
+	if hours <= 0 || o.PricePerHour <= 0 || int64(o.PricePerHour) > math.MaxInt32/hours {
+		return 0, fmt.Errorf("preço inválido para %d horas a %d créditos por hora", hours, o.PricePerHour)
+	}
+	return int(hours) * o.PricePerHour, nil
+}
+
+// getDataOffer recupera a oferta de dados do veículo; devolve nil se não houver
+func getDataOffer(ctx contractapi.TransactionContextInterface, idcarro string) (*DataOffer, error) {
+	offerKey, err := ctx.GetStub().CreateCompositeKey(dataOfferIndex, []string{idcarro})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a oferta: %s", err)
+	}
+
+	offerJSON, err := ctx.GetStub().GetState(offerKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a oferta de dados: %s", err)
+	}
+	if offerJSON == nil {
+		return nil, nil
+	}
+
+	var offer DataOffer
+	err = json.Unmarshal(offerJSON, &offer)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a oferta de dados: %s", err)
+	}
+	return &offer, nil
+}
+
+// putDataOffer grava a oferta de dados do veículo
+func putDataOffer(ctx contractapi.TransactionContextInterface, offer *DataOffer) error {
+	offerKey, err := ctx.GetStub().CreateCompositeKey(dataOfferIndex, []string{offer.VehicleID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a oferta: %s", err)
+	}
+
+	offerJSON, err := json.Marshal(offer)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a oferta de dados: %s", err)
+	}
+
+	return ctx.GetStub().PutState(offerKey, offerJSON)
+}
+
+// SetDataOffer publica ou altera a oferta dos dados do veículo; exige o proprietário
+func (s *SmartContract) SetDataOffer(ctx contractapi.TransactionContextInterface, idcarro string, pricePerHour int, accessDuration int64) error {
+	if _, _, err := requireVehicleOwner(ctx, "SetDataOffer", idcarro, false); err != nil {
+		return err
+	}
+	if pricePerHour <= 0 || pricePerHour > maxPricePerHour {
+		return fmt.Errorf("o preço por hora deve estar entre 1 e %d créditos", maxPricePerHour)
+	}
+	if accessDuration <= 0 || accessDuration > maxAccessDuration {
+		return fmt.Errorf("a validade do acesso deve estar entre 1 e %d ms", maxAccessDuration)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putDataOffer(ctx, &DataOffer{
+		VehicleID:      idcarro,
+		PricePerHour:   pricePerHour,
+		AccessDuration: accessDuration,
+		Active:         true,
+		UpdatedAt:      now,
+	})
+}
+
+// WithdrawDataOffer retira a oferta dos dados do veículo; compras já feitas continuam válidas até expirar
+func (s *SmartContract) WithdrawDataOffer(ctx contractapi.TransactionContextInterface, idcarro string) error {
+	if _, _, err := requireVehicleOwner(ctx, "WithdrawDataOffer", idcarro, false); err != nil {
+		return err
+	}
+
+	offer, err := getDataOffer(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	if offer == nil || !offer.Active {
+		return fmt.Errorf("o veículo %s não possui oferta de dados ativa", idcarro)
+	}
+
+	offer.Active = false
+	offer.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putDataOffer(ctx, offer)
+}
+
+// ListDataOffers consulta as ofertas de dados ativas
+func (s *SmartContract) ListDataOffers(ctx contractapi.TransactionContextInterface) ([]*DataOffer, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dataOfferIndex, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as ofertas de dados: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	offers := []*DataOffer{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler as ofertas de dados: %s", err)
+		}
+
+		var offer DataOffer
+		err = json.Unmarshal(queryResponse.Value, &offer)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a oferta de dados: %s", err)
+		}
+		if offer.Active {
+			offers = append(offers, &offer)
+		}
+	}
+	return offers, nil
+}
+
+// BuyVehicleData compra, para a organização do chamador (role=consumer), acesso à telemetria do veículo
+// entre from e to (inclusive, em milissegundos). O preço é debitado da carteira da organização e
+// creditado na carteira do veículo.
+func (s *SmartContract) BuyVehicleData(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) (*DataPurchase, error) {
+	caller, err := requireRole(ctx, "BuyVehicleData", RoleConsumer)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if to-from > maxPurchaseRange {
+		return nil, fmt.Errorf("intervalo de %d ms excede o limite de %d ms por compra", to-from, maxPurchaseRange)
+	}
+
+	offer, err := getDataOffer(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if offer == nil || !offer.Active {
+		return nil, fmt.Errorf("o veículo %s não possui oferta de dados ativa", idcarro)
+	}
+
+	// só faz sentido comprar dados que o proprietário consentiu compartilhar com a organização
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle == nil {
+		return nil, fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+	categories, err := consentedCategories(ctx, caller, vehicle)
+	if err != nil {
+		return nil, err
+	}
+	if !hasTelemetryCategory(categories) {
+		return nil, permissionDenied("BuyVehicleData", caller, "sem consentimento do proprietário para a telemetria do veículo "+idcarro)
+	}
+
+	buyerWalletID := orgWalletID(caller.MSPID)
+	buyerWallet, err := getWallet(ctx, buyerWalletID)
+	if err != nil {
+		return nil, err
+	}
+	if buyerWallet == nil {
+		return nil, fmt.Errorf("carteira %s não encontrada", buyerWalletID)
+	}
+	sellerWallet, err := getWallet(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if sellerWallet == nil {
+		return nil, fmt.Errorf("carteira do veículo %s não encontrada", idcarro)
+	}
+
+	price, err := offer.PurchasePrice(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if buyerWallet.Credits < price {
+		return nil, fmt.Errorf("saldo insuficiente na carteira %s: %d créditos disponíveis, %d necessários", buyerWalletID, buyerWallet.Credits, price)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	purchase := &DataPurchase{
+		ID:          ctx.GetStub().GetTxID(),
+		VehicleID:   idcarro,
+		BuyerMSP:    caller.MSPID,
+		BuyerID:     caller.ID,
+		From:        from,
+		To:          to,
+		Price:       price,
+		PurchasedAt: now,
+		ExpiresAt:   now + offer.AccessDuration,
+	}
+
+	buyerWallet, err = postCreditEntries(ctx, buyerWalletID, []*CreditEntry{{Amount: -price, Reason: ReasonDataPurchase, Reference: purchase.ID}})
+	if err != nil {
+		return nil, err
+	}
+	sellerWallet, err = postCreditEntries(ctx, idcarro, []*CreditEntry{{Amount: price, Reason: ReasonDataSale, Reference: purchase.ID}})
+	if err != nil {
+		return nil, err
+	}
+
+	purchaseKey, err := ctx.GetStub().CreateCompositeKey(dataPurchaseIndex, []string{idcarro, caller.MSPID, purchase.ID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a compra: %s", err)
+	}
+	purchaseJSON, err := json.Marshal(purchase)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao serializar a compra: %s", err)
+	}
+	err = ctx.GetStub().PutState(purchaseKey, purchaseJSON)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao armazenar a compra: %s", err)
+	}
+
+	err = emitEvent(ctx, EventNameDataPurchased, DataPurchasedPayload{
+		Purchase:      purchase,
+		BuyerBalance:  buyerWallet.Credits,
+		SellerBalance: sellerWallet.Credits,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purchase, nil
+}
+
+// getDataPurchases recupera as compras de dados do veículo feitas pela organização
+func getDataPurchases(ctx contractapi.TransactionContextInterface, idcarro string, buyerMSP string) ([]*DataPurchase, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dataPurchaseIndex, []string{idcarro, buyerMSP})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as compras de dados: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	purchases := []*DataPurchase{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler as compras de dados: %s", err)
+		}
+
+		var purchase DataPurchase
+		err = json.Unmarshal(queryResponse.Value, &purchase)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a compra de dados: %s", err)
+		}
+		purchases = append(purchases, &purchase)
+	}
+	return purchases, nil
+}
+
+// QueryDataPurchases consulta as compras de dados do veículo feitas pela organização do chamador
+func (s *SmartContract) QueryDataPurchases(ctx contractapi.TransactionContextInterface, idcarro string) ([]*DataPurchase, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return getDataPurchases(ctx, idcarro, caller.MSPID)
+}
+
+// requireDataAccess garante que o chamador pode ler a telemetria bruta do veículo entre from e to e devolve
+// as categorias de dados que ele pode ver: todas para o proprietário e administradores; para um consumidor,
+// exige uma compra válida cobrindo o intervalo e devolve as categorias com consentimento do proprietário
+func requireDataAccess(ctx contractapi.TransactionContextInterface, operation string, idcarro string, from int64, to int64) (map[string]bool, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle == nil {
+		return nil, fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+	if vehicle.IsOwner(caller) || caller.HasRole(RoleAdmin) {
+		return allCategories(), nil
+	}
+	if !caller.HasRole(RoleConsumer) {
+		return nil, permissionDenied(operation, caller, "exige o proprietário do veículo ou uma compra de dados (role=consumer)")
+	}
+
+	categories, err := consentedCategories(ctx, caller, vehicle)
+	if err != nil {
+		return nil, err
+	}
+	if !hasTelemetryCategory(categories) {
+		return nil, permissionDenied(operation, caller, "sem consentimento do proprietário para a telemetria do veículo "+idcarro)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	purchases, err := getDataPurchases(ctx, idcarro, caller.MSPID)
+	if err != nil {
+		return nil, err
+	}
+	for _, purchase := range purchases {
+		if purchase.ExpiresAt > now && purchase.From <= from && to <= purchase.To {
+			return categories, nil
+		}
+	}
+	return nil, permissionDenied(operation, caller, "nenhuma compra válida cobre os dados solicitados do veículo "+idcarro)
+}
+
+// GetVehicleData consulta as leituras do veículo entre from e to (inclusive, em milissegundos);
+// exige o proprietário, um administrador ou uma compra de dados válida para o intervalo.
+// Campos sem consentimento do proprietário para a organização do chamador são removidos.
+func (s *SmartContract) GetVehicleData(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) ([]VehicleData, error) {
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if to-from > maxTelemetryReadWindow {
+		return nil, fmt.Errorf("intervalo de %d ms excede o limite de %d ms por consulta", to-from, maxTelemetryReadWindow)
+	}
+	categories, err := requireDataAccess(ctx, "GetVehicleData", idcarro, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := getVehicleDataWindow(ctx, idcarro, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for i := range samples {
+		samples[i].Redact(categories)
+	}
+	return samples, nil
+}
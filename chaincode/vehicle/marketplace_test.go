@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPurchasePrice(t *testing.T) {
+	tests := []struct {
+		name         string
+		pricePerHour int
+		from, to     int64
+		want         int
+		wantErr      bool
+	}{
+		{"um instante cobra uma hora", 10, 0, 0, 10, false},
+		{"hora completa", 10, 0, hourMillis - 1, 10, false},
+		{"hora iniciada", 10, 0, hourMillis, 20, false},
+		{"um dia", 10, hourMillis, 25*hourMillis - 1, 240, false},
+		{"fim anterior ao início", 10, hourMillis, 0, 0, true},
+		{"preço zero", 0, 0, hourMillis - 1, 0, true},
+		{"preço negativo", -1, 0, hourMillis - 1, 0, true},
+		{"maior preço representável", math.MaxInt32, 0, hourMillis - 1, math.MaxInt32, false},
+		{"preço excede o limite", math.MaxInt32, 0, hourMillis, 0, true},
+		{"intervalo máximo", 1, 0, maxPurchaseRange - 1, 366 * 24, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offer := &DataOffer{PricePerHour: tt.pricePerHour}
+			got, err := offer.PurchasePrice(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PurchasePrice(%d, %d) erro = %v, esperado erro: %v", tt.from, tt.to, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PurchasePrice(%d, %d) = %d, esperado %d", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
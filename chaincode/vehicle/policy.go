@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// scoringPolicyKey é a chave do documento único com a política de pontuação
+const scoringPolicyKey = "SCORINGPOLICY"
+
+// ScoringPolicy reúne os limites e créditos usados pelos detectores de comportamento.
+// Penalidades são valores negativos e recompensas são valores positivos.
+type ScoringPolicy struct {
+	// Aceleração anômala: variação de velocidade acima de AnomalySpeedDelta dentro de AnomalyInterval
+	AnomalySpeedDelta float64 `json:"anomalySpeedDelta"` // km/h
+	AnomalyInterval   int64   `json:"anomalyInterval"`   // ms
+	AnomalyPenalty    int     `json:"anomalyPenalty"`
+
+	// Zigue-zague: ao menos ZigZagMinCount amostras com aceleração Y >= ZigZagAccelY e troca da aceleração Z
+	ZigZagAccelY   float64 `json:"zigzagAccelY"`
+	ZigZagMinCount int     `json:"zigzagMinCount"`
+	ZigZagPenalty  int     `json:"zigzagPenalty"`
+
+	// Curva brusca: mudança de direção entre trechos consecutivos acima de SharpTurnDirection com velocidade acima de SharpTurnSpeed
+	SharpTurnDirection float64 `json:"sharpTurnDirection"` // rad, de 0 a π
+	SharpTurnSpeed     float64 `json:"sharpTurnSpeed"`     // km/h
+	SharpTurnPenalty   int     `json:"sharpTurnPenalty"`
+
+	// Excesso de velocidade: velocidade acima do limite da zona (SpeedZone) mais SpeedingTolerance.
+	// A penalidade é SpeedingPenaltyRate por km/h acima do limite a cada minuto, arredondada para baixo.
+	SpeedingTolerance   float64 `json:"speedingTolerance"`   // km/h
+	SpeedingPenaltyRate float64 `json:"speedingPenaltyRate"` // créditos por km/h·min
+
+	// Recompensa de cada detector sem ocorrências em uma janela em que o veículo esteve em movimento
+	RewardPerWindow int `json:"rewardPerWindow"`
+
+	UpdatedBy string `json:"updatedBy,omitempty" metadata:"updatedBy,optional"`
+	UpdatedAt int64  `json:"updatedAt,omitempty" metadata:"updatedAt,optional"` // timestamp da transação, em milissegundos
+}
+
+// DefaultScoringPolicy retorna os valores usados enquanto nenhuma política for gravada no ledger
+func DefaultScoringPolicy() *ScoringPolicy {
+	return &ScoringPolicy{
+		AnomalySpeedDelta:   30,
+		AnomalyInterval:     5 * 1000,
+		AnomalyPenalty:      -50,
+		ZigZagAccelY:        0.0080,
+		ZigZagMinCount:      3,
+		ZigZagPenalty:       -40,
+		SharpTurnDirection:  0.7,
+		SharpTurnSpeed:      30,
+		SharpTurnPenalty:    -30,
+		SpeedingTolerance:   5,
+		SpeedingPenaltyRate: -2,
+		RewardPerWindow:     1,
+	}
+}
+
+// Validate verifica se os parâmetros da política são coerentes
+func (p *ScoringPolicy) Validate() error {
+	if !(p.AnomalySpeedDelta > 0) || !(p.ZigZagAccelY >= 0) || !(p.SharpTurnDirection > 0) || !(p.SharpTurnSpeed >= 0) || !(p.SpeedingTolerance >= 0) {
+		return fmt.Errorf("política de pontuação inválida: limites devem ser positivos")
+	}
+	if p.AnomalyInterval <= 0 {
+		return fmt.Errorf("política de pontuação inválida: anomalyInterval deve ser positivo")
+	}
+	if p.ZigZagMinCount < 1 {
+		return fmt.Errorf("política de pontuação inválida: zigzagMinCount deve ser ao menos 1")
+	}
+	if p.AnomalyPenalty > 0 || p.ZigZagPenalty > 0 || p.SharpTurnPenalty > 0 || !(p.SpeedingPenaltyRate <= 0) || math.IsInf(p.SpeedingPenaltyRate, -1) {
+		return fmt.Errorf("política de pontuação inválida: penalidades não podem ser positivas")
+	}
+	if p.RewardPerWindow < 0 {
+		return fmt.Errorf("política de pontuação inválida: a recompensa não pode ser negativa")
+	}
+	return nil
+}
+
+// getScoringPolicy recupera a política gravada no ledger ou, se não houver, a política padrão.
+// Campos ausentes da política gravada, como os incluídos em versões posteriores do contrato, mantêm o valor padrão.
+func getScoringPolicy(ctx contractapi.TransactionContextInterface) (*ScoringPolicy, error) {
+	policyJSON, err := ctx.GetStub().GetState(scoringPolicyKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a política de pontuação: %s", err)
+	}
+	if policyJSON == nil {
+		return DefaultScoringPolicy(), nil
+	}
+
+	policy := DefaultScoringPolicy()
+	err = json.Unmarshal(policyJSON, policy)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a política de pontuação: %s", err)
+	}
+	return policy, nil
+}
+
+// GetScoringPolicy consulta a política de pontuação em vigor
+func (s *SmartContract) GetScoringPolicy(ctx contractapi.TransactionContextInterface) (*ScoringPolicy, error) {
+	return getScoringPolicy(ctx)
+}
+
+// SetScoringPolicy atualiza a política de pontuação (somente administradores).
+// Os campos informados em policyJSON substituem os da política em vigor; os demais são mantidos.
+func (s *SmartContract) SetScoringPolicy(ctx contractapi.TransactionContextInterface, policyJSON string) error {
+	caller, err := requireRole(ctx, "SetScoringPolicy", RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	policy, err := getScoringPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(policyJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return fmt.Errorf("política de pontuação inválida: %s", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	policy.UpdatedBy = caller.ID
+	policy.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a política de pontuação: %s", err)
+	}
+
+	return ctx.GetStub().PutState(scoringPolicyKey, policyBytes)
+}
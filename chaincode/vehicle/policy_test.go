@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoringPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(p *ScoringPolicy)
+		wantErr bool
+	}{
+		{"política padrão", func(p *ScoringPolicy) {}, false},
+		{"recompensa zero", func(p *ScoringPolicy) { p.RewardPerWindow = 0 }, false},
+		{"recompensa negativa", func(p *ScoringPolicy) { p.RewardPerWindow = -1 }, true},
+		{"penalidade zero", func(p *ScoringPolicy) { p.AnomalyPenalty = 0 }, false},
+		{"penalidade positiva", func(p *ScoringPolicy) { p.SharpTurnPenalty = 1 }, true},
+		{"taxa de excesso positiva", func(p *ScoringPolicy) { p.SpeedingPenaltyRate = 0.5 }, true},
+		{"taxa de excesso infinita", func(p *ScoringPolicy) { p.SpeedingPenaltyRate = math.Inf(-1) }, true},
+		{"limite NaN", func(p *ScoringPolicy) { p.AnomalySpeedDelta = math.NaN() }, true},
+		{"limite zero", func(p *ScoringPolicy) { p.SharpTurnDirection = 0 }, true},
+		{"intervalo zero", func(p *ScoringPolicy) { p.AnomalyInterval = 0 }, true},
+		{"mínimo de zigue-zagues zero", func(p *ScoringPolicy) { p.ZigZagMinCount = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultScoringPolicy()
+			tt.modify(policy)
+			if err := policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() erro = %v, esperado erro: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// telemetryCollection é a coleção de dados privados com as amostras completas de telemetria
+// (ver collections_config.json). O world state público guarda apenas um hash com sal de cada amostra.
+// Os peers expurgam cada valor privado blockToLive blocos depois de gravado.
+const telemetryCollection = "vehicleTelemetryPrivate"
+
+// Chaves do mapa transiente usadas pelas transações de telemetria
+const (
+	transientSalt      = "salt"      // sal aleatório escolhido pelo dispositivo, ao menos minSaltSize bytes
+	transientSignature = "signature" // assinatura do dispositivo sobre a mensagem de telemetria
+)
+
+// minSaltSize é o tamanho mínimo do sal, em bytes
+const minSaltSize = 16
+
+// VehicleDataCommitment é o registro público de uma amostra: o hash com sal da amostra guardada na coleção privada
+type VehicleDataCommitment struct {
+	VehicleID string `json:"vehicleId"`
+	TimeStamp int64  `json:"timestamp"`
+	Hash      string `json:"hash"` // SHA-256 de sal || JSON da amostra, em hexadecimal
+	TxID      string `json:"txId"`
+}
+
+// privateVehicleData é a amostra guardada na coleção privada, com o sal usado no hash público.
+// DocType e VehicleID permitem as consultas ricas no CouchDB (ver SearchVehicleData); o hash cobre apenas a amostra.
+type privateVehicleData struct {
+	DocType   string `json:"docType"`
+	VehicleID string `json:"vehicleId"`
+	VehicleData
+	Salt string `json:"salt"` // em hexadecimal
+}
+
+// getTransientFields lê do mapa transiente os campos informados, na ordem pedida
+func getTransientFields(ctx contractapi.TransactionContextInterface, names ...string) ([]string, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o mapa transiente: %s", err)
+	}
+
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		value, ok := transient[name]
+		if !ok {
+			return nil, fmt.Errorf("campo %q ausente no mapa transiente", name)
+		}
+		values = append(values, string(value))
+	}
+	return values, nil
+}
+
+// getTransientSalt lê o sal do mapa transiente
+func getTransientSalt(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o mapa transiente: %s", err)
+	}
+	salt := transient[transientSalt]
+	if len(salt) < minSaltSize {
+		return nil, fmt.Errorf("sal ausente ou curto no mapa transiente: ao menos %d bytes são exigidos", minSaltSize)
+	}
+	return salt, nil
+}
+
+// sampleSalt deriva o sal de cada amostra a partir do sal da transação e da chave da amostra,
+// para que amostras idênticas não produzam o mesmo hash público
+func sampleSalt(salt []byte, key string) []byte {
+	digest := sha256.Sum256(append(append([]byte{}, salt...), key...))
+	return digest[:]
+}
+
+// getSampleSalt recupera da coleção privada o sal de uma amostra já armazenada
+func getSampleSalt(ctx contractapi.TransactionContextInterface, idcarro string, timestamp int64) ([]byte, error) {
+	privateJSON, err := ctx.GetStub().GetPrivateData(telemetryCollection, vehicleDataKey(idcarro, timestamp))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler os dados privados do veículo: %s", err)
+	}
+	if privateJSON == nil {
+		return nil, fmt.Errorf("amostra do veículo %s em %d não encontrada", idcarro, timestamp)
+	}
+
+	var private privateVehicleData
+	err = json.Unmarshal(privateJSON, &private)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+	}
+	salt, err := hex.DecodeString(private.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("sal inválido na amostra do veículo %s em %d", idcarro, timestamp)
+	}
+	return salt, nil
+}
+
+// VehicleDataHash calcula o hash público de uma amostra: SHA-256 de sal || JSON da amostra
+func VehicleDataHash(vehicleData *VehicleData, salt []byte) (string, error) {
+	vehicleDataJSON, err := json.Marshal(vehicleData)
+	if err != nil {
+		return "", fmt.Errorf("falha ao serializar os dados do veículo: %s", err)
+	}
+	digest := sha256.Sum256(append(append([]byte{}, salt...), vehicleDataJSON...))
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// putPrivateVehicleData grava a amostra na coleção privada e o seu hash com sal no world state público
+func putPrivateVehicleData(ctx contractapi.TransactionContextInterface, idcarro string, vehicleData *VehicleData, salt []byte) error {
+	key := vehicleDataKey(idcarro, vehicleData.TimeStamp)
+	salt = sampleSalt(salt, key)
+
+	hash, err := VehicleDataHash(vehicleData, salt)
+	if err != nil {
+		return err
+	}
+
+	privateJSON, err := json.Marshal(privateVehicleData{
+		DocType:     docTypeVehicleData,
+		VehicleID:   idcarro,
+		VehicleData: *vehicleData,
+		Salt:        hex.EncodeToString(salt),
+	})
+	if err != nil {
+		return fmt.Errorf("falha ao serializar os dados do veículo: %s", err)
+	}
+	err = ctx.GetStub().PutPrivateData(telemetryCollection, key, privateJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao armazenar os dados privados do veículo: %s", err)
+	}
+
+	commitmentJSON, err := json.Marshal(VehicleDataCommitment{
+		VehicleID: idcarro,
+		TimeStamp: vehicleData.TimeStamp,
+		Hash:      hash,
+		TxID:      ctx.GetStub().GetTxID(),
+	})
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o hash dos dados do veículo: %s", err)
+	}
+	err = ctx.GetStub().PutState(key, commitmentJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao armazenar o hash dos dados do veículo: %s", err)
+	}
+	return nil
+}
+
+// GetVehicleDataCommitment consulta o hash público de uma amostra, que qualquer organização pode
+// comparar com a amostra e o sal revelados pelo proprietário
+func (s *SmartContract) GetVehicleDataCommitment(ctx contractapi.TransactionContextInterface, idcarro string, timestamp int64) (*VehicleDataCommitment, error) {
+	commitmentJSON, err := ctx.GetStub().GetState(vehicleDataKey(idcarro, timestamp))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o hash dos dados do veículo: %s", err)
+	}
+	if commitmentJSON == nil {
+		return nil, fmt.Errorf("amostra do veículo %s em %d não encontrada", idcarro, timestamp)
+	}
+
+	var commitment VehicleDataCommitment
+	err = json.Unmarshal(commitmentJSON, &commitment)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar o hash dos dados do veículo: %s", err)
+	}
+	return &commitment, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Valores do campo docType, que distingue os tipos de documento nas consultas ricas do CouchDB
+const (
+	docTypeVehicleData         = "vehicleData"         // amostra na coleção privada
+	docTypeBehaviorEvent       = "behaviorEvent"       // evento de comportamento no world state público
+	docTypeBehaviorEventDetail = "behaviorEventDetail" // posição e valor observado do evento, na coleção privada
+	docTypeTripSummary         = "tripSummary"         // distância e velocidades do resumo da viagem, na coleção privada
+	docTypeDrivingDistance     = "drivingDistance"     // distância dos agregados diários de condução, na coleção privada
+)
+
+// Índices do CouchDB distribuídos com o chaincode em META-INF/statedb/couchdb
+const (
+	vehicleDataIndexDoc   = "indexVehicleDataDoc"   // coleção privada: docType, vehicleId, timestamp
+	behaviorEventIndexDoc = "indexBehaviorEventDoc" // world state: docType, vehicleId, timestamp
+)
+
+// maxQueryPageSize limita o número de eventos devolvidos por página em SearchBehaviorEvents
+const maxQueryPageSize = 200
+
+// BehaviorEventPage é uma página do resultado de SearchBehaviorEvents
+type BehaviorEventPage struct {
+	Events              []*BehaviorEvent `json:"events"`
+	FetchedRecordsCount int32            `json:"fetchedRecordsCount"`
+	Bookmark            string           `json:"bookmark"` // vazio quando não há mais páginas
+}
+
+// buildRichQuery monta a consulta do CouchDB a partir do seletor, ordenando por veículo e timestamp com o índice informado.
+// O seletor é serializado com json.Marshal, de modo que os valores informados pelo chamador nunca alteram a estrutura da consulta.
+func buildRichQuery(selector map[string]interface{}, indexDoc string) (string, error) {
+	query := map[string]interface{}{
+		"selector":  selector,
+		"sort":      []map[string]string{{"docType": "asc"}, {"vehicleId": "asc"}, {"timestamp": "asc"}},
+		"use_index": []string{"_design/" + indexDoc},
+	}
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return "", fmt.Errorf("falha ao montar a consulta: %s", err)
+	}
+	return string(queryJSON), nil
+}
+
+// timeRangeSelector monta o seletor de um tipo de documento do veículo com timestamp entre from e to (inclusive)
+func timeRangeSelector(docType string, idcarro string, from int64, to int64) map[string]interface{} {
+	return map[string]interface{}{
+		"docType":   docType,
+		"vehicleId": idcarro,
+		"timestamp": map[string]interface{}{"$gte": from, "$lte": to},
+	}
+}
+
+// SearchVehicleData consulta no CouchDB as leituras do veículo entre from e to (inclusive, em milissegundos);
+// se minSpeed for positivo, apenas as leituras com velocidade acima de minSpeed são devolvidas.
+// Exige o proprietário, um administrador ou uma compra de dados válida para o intervalo; filtrar por velocidade
+// exige também consentimento para a categoria speed. Campos sem consentimento são removidos.
+func (s *SmartContract) SearchVehicleData(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64, minSpeed float64) ([]VehicleData, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if to-from > maxTelemetryReadWindow {
+		return nil, fmt.Errorf("intervalo de %d ms excede o limite de %d ms por consulta", to-from, maxTelemetryReadWindow)
+	}
+	categories, err := requireDataAccess(ctx, "SearchVehicleData", idcarro, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := timeRangeSelector(docTypeVehicleData, idcarro, from, to)
+	if minSpeed > 0 {
+		if !categories[CategorySpeed] {
+			caller, err := getCaller(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return nil, permissionDenied("SearchVehicleData", caller, "filtrar por velocidade exige consentimento para a categoria "+CategorySpeed)
+		}
+		selector["speed"] = map[string]interface{}{"$gt": minSpeed}
+	}
+	query, err := buildRichQuery(selector, vehicleDataIndexDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataQueryResult(telemetryCollection, query)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as amostras do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	samples := []VehicleData{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre as amostras do veículo: %s", err)
+		}
+
+		var vehicleData VehicleData
+		err = json.Unmarshal(queryResponse.Value, &vehicleData)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+		}
+		vehicleData.Redact(categories)
+		samples = append(samples, vehicleData)
+	}
+	return samples, nil
+}
+
+// SearchBehaviorEvents consulta no CouchDB, em páginas, os eventos de comportamento do veículo entre from e to
+// (inclusive). eventType e severity filtram por tipo e severidade; vazios, aceitam qualquer valor.
+// Exige consentimento para a categoria scores; a posição dos eventos exige também a categoria location
+// e o valor observado, a categoria speed.
+func (s *SmartContract) SearchBehaviorEvents(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64, eventType string, severity string, pageSize int32, bookmark string) (*BehaviorEventPage, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if pageSize < 1 || pageSize > maxQueryPageSize {
+		return nil, fmt.Errorf("tamanho de página inválido %d: use de 1 a %d", pageSize, maxQueryPageSize)
+	}
+	categories, err := requireConsent(ctx, "SearchBehaviorEvents", idcarro, CategoryScores)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := timeRangeSelector(docTypeBehaviorEvent, idcarro, from, to)
+	if eventType != "" {
+		if !isBehaviorEventType(eventType) {
+			return nil, fmt.Errorf("tipo de evento desconhecido %q", eventType)
+		}
+		selector["type"] = eventType
+	}
+	if severity != "" {
+		if severity != SeverityLow && severity != SeverityMedium && severity != SeverityHigh {
+			return nil, fmt.Errorf("severidade desconhecida %q: use %s, %s ou %s", severity, SeverityLow, SeverityMedium, SeverityHigh)
+		}
+		selector["severity"] = severity
+	}
+	query, err := buildRichQuery(selector, behaviorEventIndexDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar os eventos do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &BehaviorEventPage{Events: []*BehaviorEvent{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre os eventos do veículo: %s", err)
+		}
+
+		var event BehaviorEvent
+		err = json.Unmarshal(queryResponse.Value, &event)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o evento de comportamento: %s", err)
+		}
+		if err := loadBehaviorEventDetail(ctx, &event, categories); err != nil {
+			return nil, err
+		}
+		page.Events = append(page.Events, &event)
+	}
+
+	page.FetchedRecordsCount = metadata.GetFetchedRecordsCount()
+	page.Bookmark = metadata.GetBookmark()
+	return page, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// vehicleIndex é o prefixo da chave composta VEHICLE~placa
+const vehicleIndex = "VEHICLE"
+
+var (
+	// platePattern aceita placas em letras maiúsculas e dígitos, com hífen opcional (ABC1234, ABC1D23, ABC-1234)
+	platePattern = regexp.MustCompile(`^[A-Z0-9-]{1,16}$`)
+	// vinPattern aceita o chassi (VIN) de 17 caracteres, que não usa as letras I, O e Q
+	vinPattern = regexp.MustCompile(`^[A-HJ-NPR-Z0-9]{17}$`)
+)
+
+// Vehicle é o registro de um veículo, do seu proprietário e do dispositivo de telemetria embarcado
+type Vehicle struct {
+	Plate        string `json:"plate"`
+	VIN          string `json:"vin"`
+	OwnerMSP     string `json:"ownerMsp"`
+	Owner        string `json:"owner"`      // identidade do proprietário no MSP
+	DeviceCert   string `json:"deviceCert"` // certificado PEM do dispositivo de telemetria
+	RegisteredAt int64  `json:"registeredAt"`
+	UpdatedAt    int64  `json:"updatedAt"`
+}
+
+// IsOwner informa se o chamador é o proprietário do veículo
+func (v *Vehicle) IsOwner(caller *Caller) bool {
+	return caller.MSPID == v.OwnerMSP && caller.ID == v.Owner
+}
+
+// validatePlate verifica o formato da placa usada como identificador do veículo
+func validatePlate(plate string) error {
+	if !platePattern.MatchString(plate) {
+		return fmt.Errorf("placa inválida %q: use apenas letras maiúsculas, dígitos e hífen", plate)
+	}
+	return nil
+}
+
+// parseDeviceCert valida o certificado PEM do dispositivo e o devolve normalizado
+func parseDeviceCert(deviceCertPEM string) (*x509.Certificate, string, error) {
+	block, _ := pem.Decode([]byte(deviceCertPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, "", fmt.Errorf("certificado do dispositivo inválido: PEM do tipo CERTIFICATE esperado")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("certificado do dispositivo inválido: %s", err)
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})), nil
+}
+
+// getVehicle recupera o registro do veículo, ou nil se o veículo não estiver registrado
+func getVehicle(ctx contractapi.TransactionContextInterface, plate string) (*Vehicle, error) {
+	vehicleKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndex, []string{plate})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para o veículo: %s", err)
+	}
+
+	vehicleJSON, err := ctx.GetStub().GetState(vehicleKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o registro do veículo: %s", err)
+	}
+	if vehicleJSON == nil {
+		return nil, nil
+	}
+
+	var vehicle Vehicle
+	err = json.Unmarshal(vehicleJSON, &vehicle)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar o registro do veículo: %s", err)
+	}
+	return &vehicle, nil
+}
+
+// putVehicle grava o registro do veículo
+func putVehicle(ctx contractapi.TransactionContextInterface, vehicle *Vehicle) error {
+	vehicleKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndex, []string{vehicle.Plate})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o veículo: %s", err)
+	}
+
+	vehicleJSON, err := json.Marshal(vehicle)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o registro do veículo: %s", err)
+	}
+
+	return ctx.GetStub().PutState(vehicleKey, vehicleJSON)
+}
+
+// requireVehicleOwner garante que o chamador é o proprietário do veículo ou, se allowAdmin, um administrador
+func requireVehicleOwner(ctx contractapi.TransactionContextInterface, operation string, plate string, allowAdmin bool) (*Caller, *Vehicle, error) {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	vehicle, err := getVehicle(ctx, plate)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vehicle == nil {
+		return nil, nil, fmt.Errorf("veículo %s não registrado", plate)
+	}
+	if vehicle.IsOwner(caller) || (allowAdmin && caller.HasRole(RoleAdmin)) {
+		return caller, vehicle, nil
+	}
+	return nil, nil, permissionDenied(operation, caller, "exige o proprietário do veículo "+plate)
+}
+
+// RegisterVehicle registra um veículo tendo o chamador (role=owner) como proprietário e vincula o dispositivo de telemetria
+func (s *SmartContract) RegisterVehicle(ctx contractapi.TransactionContextInterface, plate string, vin string, deviceCertPEM string) error {
+	caller, err := requireRole(ctx, "RegisterVehicle", RoleOwner)
+	if err != nil {
+		return err
+	}
+	if err := validatePlate(plate); err != nil {
+		return err
+	}
+	if !vinPattern.MatchString(vin) {
+		return fmt.Errorf("chassi (VIN) inválido %q", vin)
+	}
+	_, deviceCert, err := parseDeviceCert(deviceCertPEM)
+	if err != nil {
+		return err
+	}
+
+	existing, err := getVehicle(ctx, plate)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("veículo %s já registrado", plate)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putVehicle(ctx, &Vehicle{
+		Plate:        plate,
+		VIN:          vin,
+		OwnerMSP:     caller.MSPID,
+		Owner:        caller.ID,
+		DeviceCert:   deviceCert,
+		RegisteredAt: now,
+		UpdatedAt:    now,
+	})
+}
+
+// GetVehicle consulta o registro do veículo; exige o proprietário, um administrador ou consentimento
+// para a categoria scores
+func (s *SmartContract) GetVehicle(ctx contractapi.TransactionContextInterface, plate string) (*Vehicle, error) {
+	if _, err := requireConsent(ctx, "GetVehicle", plate, CategoryScores); err != nil {
+		return nil, err
+	}
+	return getVehicle(ctx, plate)
+}
+
+// UpdateVehicle altera o chassi e o dispositivo vinculado ao veículo; exige o proprietário ou um administrador
+func (s *SmartContract) UpdateVehicle(ctx contractapi.TransactionContextInterface, plate string, vin string, deviceCertPEM string) error {
+	_, vehicle, err := requireVehicleOwner(ctx, "UpdateVehicle", plate, true)
+	if err != nil {
+		return err
+	}
+	if !vinPattern.MatchString(vin) {
+		return fmt.Errorf("chassi (VIN) inválido %q", vin)
+	}
+	_, deviceCert, err := parseDeviceCert(deviceCertPEM)
+	if err != nil {
+		return err
+	}
+
+	vehicle.VIN = vin
+	vehicle.DeviceCert = deviceCert
+	vehicle.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putVehicle(ctx, vehicle)
+}
+
+// isBoundDevice informa se o certificado do chamador é o certificado do dispositivo registrado para o veículo
+func isBoundDevice(ctx contractapi.TransactionContextInterface, vehicle *Vehicle) (bool, error) {
+	callerCert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil || callerCert == nil {
+		return false, fmt.Errorf("falha ao obter o certificado do chamador: %v", err)
+	}
+	deviceCert, _, err := parseDeviceCert(vehicle.DeviceCert)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(callerCert.Raw, deviceCert.Raw), nil
+}
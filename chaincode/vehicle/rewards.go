@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves compostas do catálogo de recompensas
+const (
+	rewardItemIndex = "REWARD"  // chave composta REWARD~item
+	voucherIndex    = "VOUCHER" // chave composta VOUCHER~voucher
+)
+
+// Situações de um voucher de resgate
+const (
+	VoucherIssued    = "ISSUED"
+	VoucherFulfilled = "FULFILLED"
+)
+
+// RewardItem é um item do catálogo de recompensas oferecido por um parceiro
+type RewardItem struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"` // em créditos
+	Stock       int    `json:"stock"`
+	PartnerMSP  string `json:"partnerMsp"` // organização do parceiro que emite o item
+	UpdatedAt   int64  `json:"updatedAt"`
+}
+
+// Voucher registra o resgate de um item; o parceiro emissor o marca como atendido
+type Voucher struct {
+	ID          string `json:"id"` // identificador da transação de resgate
+	VehicleID   string `json:"vehicleId"`
+	ItemID      string `json:"itemId"`
+	PartnerMSP  string `json:"partnerMsp"`
+	Price       int    `json:"price"`
+	Status      string `json:"status"`
+	IssuedAt    int64  `json:"issuedAt"`
+	FulfilledAt int64  `json:"fulfilledAt,omitempty" metadata:"fulfilledAt,optional"`
+	FulfilledBy string `json:"fulfilledBy,omitempty" metadata:"fulfilledBy,optional"`
+}
+
+// getRewardItem recupera um item do catálogo, ou nil se ele não existir
+func getRewardItem(ctx contractapi.TransactionContextInterface, itemID string) (*RewardItem, error) {
+	itemKey, err := ctx.GetStub().CreateCompositeKey(rewardItemIndex, []string{itemID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para o item: %s", err)
+	}
+
+	itemJSON, err := ctx.GetStub().GetState(itemKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o item do catálogo: %s", err)
+	}
+	if itemJSON == nil {
+		return nil, nil
+	}
+
+	var item RewardItem
+	err = json.Unmarshal(itemJSON, &item)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar o item do catálogo: %s", err)
+	}
+	return &item, nil
+}
+
+// putRewardItem grava um item do catálogo
+func putRewardItem(ctx contractapi.TransactionContextInterface, item *RewardItem) error {
+	itemKey, err := ctx.GetStub().CreateCompositeKey(rewardItemIndex, []string{item.ID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o item: %s", err)
+	}
+
+	itemJSON, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o item do catálogo: %s", err)
+	}
+
+	return ctx.GetStub().PutState(itemKey, itemJSON)
+}
+
+// getVoucher recupera um voucher de resgate
+func getVoucher(ctx contractapi.TransactionContextInterface, voucherID string) (*Voucher, error) {
+	voucherKey, err := ctx.GetStub().CreateCompositeKey(voucherIndex, []string{voucherID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para o voucher: %s", err)
+	}
+
+	voucherJSON, err := ctx.GetStub().GetState(voucherKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler o voucher: %s", err)
+	}
+	if voucherJSON == nil {
+		return nil, fmt.Errorf("voucher %s não encontrado", voucherID)
+	}
+
+	var voucher Voucher
+	err = json.Unmarshal(voucherJSON, &voucher)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar o voucher: %s", err)
+	}
+	return &voucher, nil
+}
+
+// putVoucher grava um voucher de resgate
+func putVoucher(ctx contractapi.TransactionContextInterface, voucher *Voucher) error {
+	voucherKey, err := ctx.GetStub().CreateCompositeKey(voucherIndex, []string{voucher.ID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para o voucher: %s", err)
+	}
+
+	voucherJSON, err := json.Marshal(voucher)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar o voucher: %s", err)
+	}
+
+	return ctx.GetStub().PutState(voucherKey, voucherJSON)
+}
+
+// AddRewardItem inclui um item no catálogo emitido pela organização do parceiro chamador (role=partner)
+func (s *SmartContract) AddRewardItem(ctx contractapi.TransactionContextInterface, itemID string, name string, description string, price int, stock int) error {
+	caller, err := requireRole(ctx, "AddRewardItem", RolePartner)
+	if err != nil {
+		return err
+	}
+	if itemID == "" || name == "" {
+		return fmt.Errorf("identificador e nome do item são obrigatórios")
+	}
+	if price <= 0 || stock < 0 {
+		return fmt.Errorf("preço deve ser positivo e estoque não pode ser negativo")
+	}
+	existing, err := getRewardItem(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("item %s já existe no catálogo", itemID)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putRewardItem(ctx, &RewardItem{
+		ID:          itemID,
+		Name:        name,
+		Description: description,
+		Price:       price,
+		Stock:       stock,
+		PartnerMSP:  caller.MSPID,
+		UpdatedAt:   now,
+	})
+}
+
+// UpdateRewardItem altera o preço e o estoque de um item; exige um parceiro da organização emissora
+func (s *SmartContract) UpdateRewardItem(ctx contractapi.TransactionContextInterface, itemID string, price int, stock int) error {
+	caller, err := requireRole(ctx, "UpdateRewardItem", RolePartner)
+	if err != nil {
+		return err
+	}
+	item, err := getRewardItem(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("item %s não encontrado no catálogo", itemID)
+	}
+	if item.PartnerMSP != caller.MSPID {
+		return permissionDenied("UpdateRewardItem", caller, "item emitido por "+item.PartnerMSP)
+	}
+	if price <= 0 || stock < 0 {
+		return fmt.Errorf("preço deve ser positivo e estoque não pode ser negativo")
+	}
+
+	item.Price = price
+	item.Stock = stock
+	item.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putRewardItem(ctx, item)
+}
+
+// GetRewardItem consulta um item do catálogo
+func (s *SmartContract) GetRewardItem(ctx contractapi.TransactionContextInterface, itemID string) (*RewardItem, error) {
+	item, err := getRewardItem(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("item %s não encontrado no catálogo", itemID)
+	}
+	return item, nil
+}
+
+// ListRewardItems consulta todos os itens do catálogo
+func (s *SmartContract) ListRewardItems(ctx contractapi.TransactionContextInterface) ([]*RewardItem, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(rewardItemIndex, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar o catálogo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	items := []*RewardItem{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler o catálogo: %s", err)
+		}
+
+		var item RewardItem
+		err = json.Unmarshal(queryResponse.Value, &item)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o item do catálogo: %s", err)
+		}
+		items = append(items, &item)
+	}
+	return items, nil
+}
+
+// RedeemReward troca créditos da carteira do veículo por um item do catálogo; exige o titular da carteira.
+// O valor é debitado no extrato, o estoque é reduzido e um voucher é emitido com o ID da transação.
+func (s *SmartContract) RedeemReward(ctx contractapi.TransactionContextInterface, idcarro string, itemID string) (*Voucher, error) {
+	if _, err := requireWalletOwner(ctx, "RedeemReward", idcarro); err != nil {
+		return nil, err
+	}
+
+	item, err := getRewardItem(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("item %s não encontrado no catálogo", itemID)
+	}
+	if item.Stock < 1 {
+		return nil, fmt.Errorf("item %s sem estoque", itemID)
+	}
+
+	wallet, err := getWallet(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, fmt.Errorf("carteira %s não encontrada", idcarro)
+	}
+	if wallet.Credits < item.Price {
+		return nil, fmt.Errorf("saldo insuficiente na carteira %s: %d créditos disponíveis, %d necessários", idcarro, wallet.Credits, item.Price)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	voucher := &Voucher{
+		ID:         ctx.GetStub().GetTxID(),
+		VehicleID:  idcarro,
+		ItemID:     item.ID,
+		PartnerMSP: item.PartnerMSP,
+		Price:      item.Price,
+		Status:     VoucherIssued,
+		IssuedAt:   now,
+	}
+
+	wallet, err = postCreditEntries(ctx, idcarro, []*CreditEntry{{Amount: -item.Price, Reason: ReasonRedemption, Reference: voucher.ID}})
+	if err != nil {
+		return nil, err
+	}
+
+	item.Stock--
+	item.UpdatedAt = now
+	if err := putRewardItem(ctx, item); err != nil {
+		return nil, err
+	}
+	if err := putVoucher(ctx, voucher); err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, EventNameRewardRedeemed, RewardRedeemedPayload{Voucher: voucher, Balance: wallet.Credits})
+	if err != nil {
+		return nil, err
+	}
+	return voucher, nil
+}
+
+// GetVoucher consulta um voucher de resgate
+func (s *SmartContract) GetVoucher(ctx contractapi.TransactionContextInterface, voucherID string) (*Voucher, error) {
+	return getVoucher(ctx, voucherID)
+}
+
+// FulfillVoucher marca o voucher como atendido; exige um parceiro da organização emissora do item
+func (s *SmartContract) FulfillVoucher(ctx contractapi.TransactionContextInterface, voucherID string) error {
+	caller, err := requireRole(ctx, "FulfillVoucher", RolePartner)
+	if err != nil {
+		return err
+	}
+	voucher, err := getVoucher(ctx, voucherID)
+	if err != nil {
+		return err
+	}
+	if voucher.PartnerMSP != caller.MSPID {
+		return permissionDenied("FulfillVoucher", caller, "voucher emitido por "+voucher.PartnerMSP)
+	}
+	if voucher.Status != VoucherIssued {
+		return fmt.Errorf("voucher %s já foi atendido", voucherID)
+	}
+
+	voucher.Status = VoucherFulfilled
+	voucher.FulfilledBy = caller.ID
+	voucher.FulfilledAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putVoucher(ctx, voucher)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// drivingStatsIndex é o prefixo da chave composta DRIVINGSTATS~carteira~dia, com os agregados diários das análises
+const drivingStatsIndex = "DRIVINGSTATS"
+
+// dayMillis é a duração de um dia (UTC) em milissegundos, a granularidade dos agregados de condução
+const dayMillis = 24 * hourMillis
+
+// maxScoreDays limita o número de dias agregados por GetDrivingScore e GetFleetReport
+const maxScoreDays = 366
+
+// DrivingStats acumula, para uma carteira (veículo ou motorista) e um dia, as janelas analisadas, os eventos de
+// comportamento e os créditos das análises. Os agregados do veículo incluem os créditos lançados na carteira do
+// motorista da sessão, para que o escore reflita toda a condução do veículo. A distância percorrida revela o quanto
+// o veículo circulou: não é gravada no registro público, e sim na coleção privada (ver drivingDistance).
+type DrivingStats struct {
+	WalletID    string         `json:"walletId"`
+	Day         int64          `json:"day"`     // dias desde 1970-01-01 (UTC), pelo início da janela analisada
+	Windows     int            `json:"windows"` // janelas de analysisWindow ms analisadas
+	DistanceKm  float64        `json:"-"`
+	EventCount  int            `json:"eventCount"`
+	EventCounts map[string]int `json:"eventCounts"`
+	Credits     int            `json:"credits"`
+}
+
+// drivingDistance guarda na coleção privada, sob a mesma chave dos agregados diários, a distância percorrida pela
+// carteira no dia, com um sal derivado do sal da última amostra analisada
+type drivingDistance struct {
+	DocType    string  `json:"docType"`
+	WalletID   string  `json:"walletId"`
+	Day        int64   `json:"day"`
+	DistanceKm float64 `json:"distanceKm"`
+	Salt       string  `json:"salt"` // em hexadecimal
+}
+
+// Add soma os valores de delta aos agregados
+func (s *DrivingStats) Add(delta *DrivingStats) {
+	s.Windows += delta.Windows
+	s.DistanceKm += delta.DistanceKm
+	s.EventCount += delta.EventCount
+	for eventType, count := range delta.EventCounts {
+		s.EventCounts[eventType] += count
+	}
+	s.Credits += delta.Credits
+}
+
+// DrivingScore normaliza pelo tempo de condução analisado os agregados de uma carteira entre os dias que contêm From
+// e To, para comparar veículos e motoristas independentemente de quanto cada um dirige
+type DrivingScore struct {
+	WalletID       string         `json:"walletId"`
+	From           int64          `json:"from"`
+	To             int64          `json:"to"`
+	Windows        int            `json:"windows"`
+	DistanceKm     float64        `json:"distanceKm,omitempty" metadata:"distanceKm,optional"` // apenas com consentimento para a categoria location
+	EventCount     int            `json:"eventCount"`
+	EventCounts    map[string]int `json:"eventCounts"`
+	Credits        int            `json:"credits"`
+	EventsPerHour  float64        `json:"eventsPerHour"`  // zero se nenhuma janela foi analisada
+	CreditsPerHour float64        `json:"creditsPerHour"` // zero se nenhuma janela foi analisada
+}
+
+// newDrivingStats cria agregados vazios
+func newDrivingStats(walletID string, day int64) *DrivingStats {
+	return &DrivingStats{WalletID: walletID, Day: day, EventCounts: map[string]int{}}
+}
+
+// dayOf devolve o dia (UTC) do timestamp em milissegundos
+func dayOf(timestamp int64) int64 {
+	return timestamp / dayMillis
+}
+
+// drivingStatsKey monta a chave dos agregados da carteira no dia
+func drivingStatsKey(ctx contractapi.TransactionContextInterface, walletID string, day int64) (string, error) {
+	statsKey, err := ctx.GetStub().CreateCompositeKey(drivingStatsIndex, []string{walletID, fmt.Sprintf("%08d", day)})
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar chave composta para os agregados de condução: %s", err)
+	}
+	return statsKey, nil
+}
+
+// getDrivingStats recupera os agregados públicos da carteira no dia, sem a distância; devolve agregados vazios se não houver
+func getDrivingStats(ctx contractapi.TransactionContextInterface, walletID string, day int64) (*DrivingStats, error) {
+	statsKey, err := drivingStatsKey(ctx, walletID, day)
+	if err != nil {
+		return nil, err
+	}
+
+	statsJSON, err := ctx.GetStub().GetState(statsKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler os agregados de condução: %s", err)
+	}
+	stats := newDrivingStats(walletID, day)
+	if statsJSON == nil {
+		return stats, nil
+	}
+	err = json.Unmarshal(statsJSON, stats)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar os agregados de condução: %s", err)
+	}
+	return stats, nil
+}
+
+// getDrivingDistance recupera da coleção privada a distância percorrida pela carteira no dia, zero se não houver
+func getDrivingDistance(ctx contractapi.TransactionContextInterface, walletID string, day int64) (float64, error) {
+	statsKey, err := drivingStatsKey(ctx, walletID, day)
+	if err != nil {
+		return 0, err
+	}
+	distanceJSON, err := ctx.GetStub().GetPrivateData(telemetryCollection, statsKey)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao ler a distância dos agregados de condução: %s", err)
+	}
+	if distanceJSON == nil {
+		return 0, nil
+	}
+
+	var distance drivingDistance
+	err = json.Unmarshal(distanceJSON, &distance)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao desserializar a distância dos agregados de condução: %s", err)
+	}
+	return distance.DistanceKm, nil
+}
+
+// addDrivingStats soma delta aos agregados da carteira no dia. A distância de delta é somada na coleção privada,
+// com um sal derivado de salt, o sal da última amostra analisada; salt pode ser nil se delta não tem distância.
+// Assim como postCreditEntries, deve ser chamada no máximo uma vez por carteira e dia em cada transação.
+func addDrivingStats(ctx contractapi.TransactionContextInterface, walletID string, day int64, delta *DrivingStats, salt []byte) error {
+	stats, err := getDrivingStats(ctx, walletID, day)
+	if err != nil {
+		return err
+	}
+	stats.Add(delta)
+
+	statsKey, err := drivingStatsKey(ctx, walletID, day)
+	if err != nil {
+		return err
+	}
+	if delta.DistanceKm > 0 {
+		distance, err := getDrivingDistance(ctx, walletID, day)
+		if err != nil {
+			return err
+		}
+		distanceJSON, err := json.Marshal(drivingDistance{
+			DocType:    docTypeDrivingDistance,
+			WalletID:   walletID,
+			Day:        day,
+			DistanceKm: distance + delta.DistanceKm,
+			Salt:       hex.EncodeToString(sampleSalt(salt, statsKey)),
+		})
+		if err != nil {
+			return fmt.Errorf("falha ao serializar a distância dos agregados de condução: %s", err)
+		}
+		if err := ctx.GetStub().PutPrivateData(telemetryCollection, statsKey, distanceJSON); err != nil {
+			return fmt.Errorf("falha ao armazenar a distância dos agregados de condução: %s", err)
+		}
+	}
+
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar os agregados de condução: %s", err)
+	}
+	return ctx.GetStub().PutState(statsKey, statsJSON)
+}
+
+// windowReward calcula a recompensa de um detector sem ocorrências na janela: rewardPerWindow se o veículo esteve em
+// movimento em alguma amostra, e zero se ficou parado. A recompensa não depende da distância, que não é pública.
+func windowReward(rewardPerWindow int, samples []VehicleData) int {
+	for _, sample := range samples {
+		if sample.Speed > 0 {
+			return rewardPerWindow
+		}
+	}
+	return 0
+}
+
+// getDrivingScore soma os agregados diários da carteira entre os dias que contêm from e to e os normaliza pelo tempo
+// de condução analisado; a distância é lida da coleção privada apenas se withDistance
+func getDrivingScore(ctx contractapi.TransactionContextInterface, walletID string, from int64, to int64, withDistance bool) (*DrivingScore, error) {
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	if dayOf(to)-dayOf(from) >= maxScoreDays {
+		return nil, fmt.Errorf("intervalo excede o limite de %d dias", maxScoreDays)
+	}
+
+	total := newDrivingStats(walletID, dayOf(from))
+	for day := dayOf(from); day <= dayOf(to); day++ {
+		stats, err := getDrivingStats(ctx, walletID, day)
+		if err != nil {
+			return nil, err
+		}
+		if withDistance {
+			stats.DistanceKm, err = getDrivingDistance(ctx, walletID, day)
+			if err != nil {
+				return nil, err
+			}
+		}
+		total.Add(stats)
+	}
+
+	score := &DrivingScore{
+		WalletID:    walletID,
+		From:        from,
+		To:          to,
+		Windows:     total.Windows,
+		DistanceKm:  total.DistanceKm,
+		EventCount:  total.EventCount,
+		EventCounts: total.EventCounts,
+		Credits:     total.Credits,
+	}
+	if total.Windows > 0 {
+		hours := float64(total.Windows) * analysisWindow / hourMillis
+		score.EventsPerHour = float64(total.EventCount) / hours
+		score.CreditsPerHour = float64(total.Credits) / hours
+	}
+	return score, nil
+}
+
+// GetDrivingScore consulta as janelas analisadas, os eventos e os créditos das análises de uma carteira de veículo ou
+// de motorista entre os dias (UTC) que contêm from e to, normalizados por hora de condução analisada. Exige a mesma
+// permissão da consulta à carteira; a distância percorrida só é devolvida ao motorista da carteira, a administradores
+// e, nas carteiras de veículos, a quem tem consentimento para a categoria location.
+func (s *SmartContract) GetDrivingScore(ctx contractapi.TransactionContextInterface, walletID string, from int64, to int64) (*DrivingScore, error) {
+	if strings.HasPrefix(walletID, orgWalletPrefix) || strings.HasPrefix(walletID, driverWalletPrefix) {
+		if err := requireWalletRead(ctx, "GetDrivingScore", walletID); err != nil {
+			return nil, err
+		}
+		return getDrivingScore(ctx, walletID, from, to, true)
+	}
+
+	categories, err := requireConsent(ctx, "GetDrivingScore", walletID, CategoryScores)
+	if err != nil {
+		return nil, err
+	}
+	return getDrivingScore(ctx, walletID, from, to, categories[CategoryLocation])
+}
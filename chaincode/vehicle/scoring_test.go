@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestWindowReward(t *testing.T) {
+	stopped := []VehicleData{{Speed: 0}, {Speed: 0}}
+	moving := []VehicleData{{Speed: 0}, {Speed: 0.5}, {Speed: 0}}
+
+	tests := []struct {
+		name   string
+		reward int
+		window []VehicleData
+		want   int
+	}{
+		{"janela sem amostras", 1, nil, 0},
+		{"veículo parado", 1, stopped, 0},
+		{"veículo em movimento", 1, moving, 1},
+		{"recompensa configurada", 5, moving, 5},
+		{"recompensa desativada", 0, moving, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowReward(tt.reward, tt.window); got != tt.want {
+				t.Errorf("windowReward(%d) = %d, esperado %d", tt.reward, got, tt.want)
+			}
+		})
+	}
+}
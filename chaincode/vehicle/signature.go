@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// telemetrySignatureSeparator separa os campos da mensagem assinada pelo dispositivo
+const telemetrySignatureSeparator = "|"
+
+// TelemetryMessage monta a mensagem canônica assinada pelo dispositivo: a placa seguida dos
+// argumentos da transação, na ordem em que são enviados, separados por "|".
+// Para lotes, o único argumento é o array JSON exatamente como enviado.
+func TelemetryMessage(idcarro string, fields ...string) []byte {
+	return []byte(strings.Join(append([]string{idcarro}, fields...), telemetrySignatureSeparator))
+}
+
+// verifyTelemetrySignature confere a assinatura ECDSA (ASN.1 DER, codificada em base64) do SHA-256 da
+// mensagem com a chave pública do certificado do dispositivo registrado para o veículo
+func verifyTelemetrySignature(vehicle *Vehicle, message []byte, signature string) error {
+	signatureDER, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(signatureDER) == 0 {
+		return fmt.Errorf("assinatura da telemetria inválida: base64 esperado")
+	}
+
+	deviceCert, _, err := parseDeviceCert(vehicle.DeviceCert)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := deviceCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("o certificado do dispositivo do veículo %s não possui chave ECDSA", vehicle.Plate)
+	}
+
+	digest := sha256.Sum256(message)
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signatureDER) {
+		return fmt.Errorf("assinatura da telemetria não confere com o dispositivo registrado para o veículo %s", vehicle.Plate)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// speedZoneIndex é o prefixo da chave composta SPEEDZONE~zona
+const speedZoneIndex = "SPEEDZONE"
+
+// SpeedZone é uma zona com limite de velocidade mantida pelos administradores: uma área (Polygon)
+// ou um trecho de via (Path, com largura Width). Onde zonas se sobrepõem, vale o menor limite.
+type SpeedZone struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Polygon    []GeoPoint `json:"polygon,omitempty" metadata:"polygon,optional"` // vértices da área, sem repetir o primeiro
+	Path       []GeoPoint `json:"path,omitempty" metadata:"path,optional"`       // pontos ao longo do eixo da via
+	Width      float64    `json:"width,omitempty" metadata:"width,optional"`     // largura total da via, em metros
+	SpeedLimit float64    `json:"speedLimit"`                                    // km/h
+	UpdatedBy  string     `json:"updatedBy"`
+	UpdatedAt  int64      `json:"updatedAt"`
+}
+
+// Validate verifica se a zona tem identificador, limite e exatamente uma geometria válida
+func (z *SpeedZone) Validate() error {
+	if z.ID == "" || z.Name == "" {
+		return fmt.Errorf("identificador e nome da zona são obrigatórios")
+	}
+	if !(z.SpeedLimit > 0) || math.IsInf(z.SpeedLimit, 1) {
+		return fmt.Errorf("limite de velocidade da zona deve ser positivo")
+	}
+	switch {
+	case len(z.Polygon) > 0 && len(z.Path) > 0:
+		return fmt.Errorf("a zona deve ter polygon ou path, não ambos")
+	case len(z.Polygon) > 0:
+		return validateGeoPoints("polygon", z.Polygon, 3)
+	case len(z.Path) > 0:
+		if !(z.Width > 0) || math.IsInf(z.Width, 1) {
+			return fmt.Errorf("largura do trecho de via deve ser positiva")
+		}
+		return validateGeoPoints("path", z.Path, 2)
+	default:
+		return fmt.Errorf("a zona deve ter polygon ou path")
+	}
+}
+
+// Contains informa se o ponto está dentro da zona
+func (z *SpeedZone) Contains(point GeoPoint) bool {
+	if len(z.Polygon) > 0 {
+		return PointInPolygon(point, z.Polygon)
+	}
+	return DistanceToPath(point, z.Path)*1000 <= z.Width/2
+}
+
+// speedLimitAt devolve o menor limite entre as zonas que contêm o ponto, ou zero se nenhuma o contiver
+func speedLimitAt(zones []*SpeedZone, point GeoPoint) float64 {
+	limit := 0.0
+	for _, zone := range zones {
+		if zone.Contains(point) && (limit == 0 || zone.SpeedLimit < limit) {
+			limit = zone.SpeedLimit
+		}
+	}
+	return limit
+}
+
+// getSpeedZone recupera uma zona com limite de velocidade
+func getSpeedZone(ctx contractapi.TransactionContextInterface, zoneID string) (*SpeedZone, error) {
+	zoneKey, err := ctx.GetStub().CreateCompositeKey(speedZoneIndex, []string{zoneID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a zona: %s", err)
+	}
+
+	zoneJSON, err := ctx.GetStub().GetState(zoneKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a zona: %s", err)
+	}
+	if zoneJSON == nil {
+		return nil, fmt.Errorf("zona %s não encontrada", zoneID)
+	}
+
+	var zone SpeedZone
+	err = json.Unmarshal(zoneJSON, &zone)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a zona: %s", err)
+	}
+	return &zone, nil
+}
+
+// listSpeedZones recupera todas as zonas com limite de velocidade
+func listSpeedZones(ctx contractapi.TransactionContextInterface) ([]*SpeedZone, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(speedZoneIndex, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as zonas: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	zones := []*SpeedZone{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler as zonas: %s", err)
+		}
+
+		var zone SpeedZone
+		err = json.Unmarshal(queryResponse.Value, &zone)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a zona: %s", err)
+		}
+		zones = append(zones, &zone)
+	}
+	return zones, nil
+}
+
+// PutSpeedZone cria ou substitui uma zona com limite de velocidade (somente administradores).
+// zoneJSON contém id, name, speedLimit (km/h) e polygon ou path e width (m); os pontos têm latitude e longitude.
+func (s *SmartContract) PutSpeedZone(ctx contractapi.TransactionContextInterface, zoneJSON string) error {
+	caller, err := requireRole(ctx, "PutSpeedZone", RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	var zone SpeedZone
+	decoder := json.NewDecoder(strings.NewReader(zoneJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&zone); err != nil {
+		return fmt.Errorf("zona inválida: %s", err)
+	}
+	if err := zone.Validate(); err != nil {
+		return err
+	}
+
+	zone.UpdatedBy = caller.ID
+	zone.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	zoneKey, err := ctx.GetStub().CreateCompositeKey(speedZoneIndex, []string{zone.ID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a zona: %s", err)
+	}
+	zoneBytes, err := json.Marshal(zone)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a zona: %s", err)
+	}
+	return ctx.GetStub().PutState(zoneKey, zoneBytes)
+}
+
+// DeleteSpeedZone remove uma zona com limite de velocidade (somente administradores)
+func (s *SmartContract) DeleteSpeedZone(ctx contractapi.TransactionContextInterface, zoneID string) error {
+	if _, err := requireRole(ctx, "DeleteSpeedZone", RoleAdmin); err != nil {
+		return err
+	}
+	if _, err := getSpeedZone(ctx, zoneID); err != nil {
+		return err
+	}
+
+	zoneKey, err := ctx.GetStub().CreateCompositeKey(speedZoneIndex, []string{zoneID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a zona: %s", err)
+	}
+	return ctx.GetStub().DelState(zoneKey)
+}
+
+// GetSpeedZone consulta uma zona com limite de velocidade
+func (s *SmartContract) GetSpeedZone(ctx contractapi.TransactionContextInterface, zoneID string) (*SpeedZone, error) {
+	return getSpeedZone(ctx, zoneID)
+}
+
+// ListSpeedZones consulta todas as zonas com limite de velocidade
+func (s *SmartContract) ListSpeedZones(ctx contractapi.TransactionContextInterface) ([]*SpeedZone, error) {
+	return listSpeedZones(ctx)
+}
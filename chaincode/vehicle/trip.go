@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Prefixos das chaves de viagens
+const (
+	tripIndex     = "TRIP"     // chave simples TRIP~placa~início, uma por viagem
+	openTripIndex = "OPENTRIP" // chave composta OPENTRIP~placa, chave da viagem em andamento
+)
+
+// maxTripDuration limita o intervalo de amostras resumido ao encerrar uma viagem
+const maxTripDuration = 24 * hourMillis
+
+// Trip é uma viagem do veículo. StartedAt e EndedAt são timestamps da telemetria (e não da transação),
+// para que o resumo cubra exatamente as amostras registradas pelo dispositivo durante a viagem.
+type Trip struct {
+	ID         string       `json:"id"` // identificador informado pelo cliente, como o id_route do conjunto de dados
+	VehicleID  string       `json:"vehicleId"`
+	DriverID   string       `json:"driverId,omitempty" metadata:"driverId,optional"` // motorista da sessão no início da viagem
+	StartedAt  int64        `json:"startedAt"`
+	EndedAt    int64        `json:"endedAt,omitempty" metadata:"endedAt,optional"` // zero enquanto a viagem estiver em andamento
+	NetCredits int          `json:"netCredits"`                                    // saldo das análises registradas até agora, ver TripSummary.NetCredits
+	Summary    *TripSummary `json:"summary,omitempty" metadata:"summary,optional"`
+}
+
+// TripSummary resume uma viagem encerrada. NetCredits soma os lançamentos de análises registrados com a viagem em
+// andamento cujas janelas começam a partir do início da viagem, na carteira do veículo e nas dos motoristas;
+// análises posteriores ao encerramento não alteram o resumo. Distance, MaxSpeed e AverageSpeed revelam o trajeto e as velocidades:
+// ficam apenas na coleção privada (ver tripSummaryDetail) e são preenchidos nas consultas conforme o consentimento.
+type TripSummary struct {
+	Duration     int64          `json:"duration"`                                        // em milissegundos
+	Distance     float64        `json:"distance,omitempty" metadata:"distance,optional"` // em quilômetros
+	SampleCount  int            `json:"sampleCount"`
+	MaxSpeed     float64        `json:"maxSpeed,omitempty" metadata:"maxSpeed,optional"`
+	AverageSpeed float64        `json:"averageSpeed,omitempty" metadata:"averageSpeed,optional"`
+	EventCount   int            `json:"eventCount"`
+	EventCounts  map[string]int `json:"eventCounts"`
+	NetCredits   int            `json:"netCredits"`
+}
+
+// tripSummaryDetail guarda na coleção privada, sob a mesma chave da viagem, os valores do resumo que revelam
+// o trajeto e as velocidades, com um sal derivado do sal da última amostra da viagem
+type tripSummaryDetail struct {
+	DocType      string  `json:"docType"`
+	VehicleID    string  `json:"vehicleId"`
+	TripID       string  `json:"tripId"`
+	Distance     float64 `json:"distance"`
+	MaxSpeed     float64 `json:"maxSpeed"`
+	AverageSpeed float64 `json:"averageSpeed"`
+	Salt         string  `json:"salt"` // em hexadecimal
+}
+
+// public devolve uma cópia da viagem sem a distância e as velocidades do resumo
+func (t *Trip) public() *Trip {
+	public := *t
+	if t.Summary != nil {
+		summary := *t.Summary
+		summary.Distance, summary.MaxSpeed, summary.AverageSpeed = 0, 0, 0
+		public.Summary = &summary
+	}
+	return &public
+}
+
+// tripKey monta a chave da viagem; o início preenchido com zeros ordena as viagens do veículo no tempo
+func tripKey(idcarro string, startedAt int64) string {
+	return fmt.Sprintf("%s~%s~%019d", tripIndex, idcarro, startedAt)
+}
+
+// putTrip grava a viagem. Se a viagem tem resumo, a distância e as velocidades vão para a coleção privada e o
+// registro público fica sem elas; salt é o sal da última amostra da viagem, nil se a viagem não tem amostras.
+func putTrip(ctx contractapi.TransactionContextInterface, trip *Trip, salt []byte) error {
+	key := tripKey(trip.VehicleID, trip.StartedAt)
+	if trip.Summary != nil && salt != nil {
+		detailJSON, err := json.Marshal(tripSummaryDetail{
+			DocType:      docTypeTripSummary,
+			VehicleID:    trip.VehicleID,
+			TripID:       trip.ID,
+			Distance:     trip.Summary.Distance,
+			MaxSpeed:     trip.Summary.MaxSpeed,
+			AverageSpeed: trip.Summary.AverageSpeed,
+			Salt:         hex.EncodeToString(sampleSalt(salt, key)),
+		})
+		if err != nil {
+			return fmt.Errorf("falha ao serializar o resumo privado da viagem: %s", err)
+		}
+		if err := ctx.GetStub().PutPrivateData(telemetryCollection, key, detailJSON); err != nil {
+			return fmt.Errorf("falha ao armazenar o resumo privado da viagem: %s", err)
+		}
+	}
+
+	tripJSON, err := json.Marshal(trip.public())
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a viagem: %s", err)
+	}
+	return ctx.GetStub().PutState(key, tripJSON)
+}
+
+// loadTripSummaryDetail preenche o resumo da viagem com a distância, se o chamador tem consentimento para a categoria
+// location, e com as velocidades, se tem consentimento para a categoria speed
+func loadTripSummaryDetail(ctx contractapi.TransactionContextInterface, trip *Trip, categories map[string]bool) error {
+	if trip.Summary == nil || (!categories[CategoryLocation] && !categories[CategorySpeed]) {
+		return nil
+	}
+	detailJSON, err := ctx.GetStub().GetPrivateData(telemetryCollection, tripKey(trip.VehicleID, trip.StartedAt))
+	if err != nil {
+		return fmt.Errorf("falha ao ler o resumo privado da viagem: %s", err)
+	}
+	if detailJSON == nil {
+		return nil
+	}
+
+	var detail tripSummaryDetail
+	err = json.Unmarshal(detailJSON, &detail)
+	if err != nil {
+		return fmt.Errorf("falha ao desserializar o resumo privado da viagem: %s", err)
+	}
+	if categories[CategoryLocation] {
+		trip.Summary.Distance = detail.Distance
+	}
+	if categories[CategorySpeed] {
+		trip.Summary.MaxSpeed, trip.Summary.AverageSpeed = detail.MaxSpeed, detail.AverageSpeed
+	}
+	return nil
+}
+
+// getOpenTrip recupera a viagem em andamento do veículo, ou nil se não houver
+func getOpenTrip(ctx contractapi.TransactionContextInterface, idcarro string) (string, *Trip, error) {
+	openKey, err := ctx.GetStub().CreateCompositeKey(openTripIndex, []string{idcarro})
+	if err != nil {
+		return "", nil, fmt.Errorf("erro ao criar chave composta para a viagem em andamento: %s", err)
+	}
+	key, err := ctx.GetStub().GetState(openKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("falha ao ler a viagem em andamento: %s", err)
+	}
+	if key == nil {
+		return openKey, nil, nil
+	}
+
+	tripJSON, err := ctx.GetStub().GetState(string(key))
+	if err != nil {
+		return "", nil, fmt.Errorf("falha ao ler a viagem: %s", err)
+	}
+	if tripJSON == nil {
+		return openKey, nil, nil
+	}
+	var trip Trip
+	err = json.Unmarshal(tripJSON, &trip)
+	if err != nil {
+		return "", nil, fmt.Errorf("falha ao desserializar a viagem: %s", err)
+	}
+	return openKey, &trip, nil
+}
+
+// requireTripControl garante que o chamador pode iniciar e encerrar viagens do veículo:
+// o proprietário, o dispositivo vinculado ou um administrador
+func requireTripControl(ctx contractapi.TransactionContextInterface, operation string, idcarro string) error {
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return err
+	}
+	vehicle, err := getVehicle(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+	if vehicle == nil {
+		return fmt.Errorf("veículo %s não registrado", idcarro)
+	}
+	if vehicle.IsOwner(caller) {
+		return nil
+	}
+	if _, _, err := requireDevice(ctx, operation, idcarro, true); err != nil {
+		return permissionDenied(operation, caller, "exige o proprietário ou o dispositivo (role=device) do veículo "+idcarro)
+	}
+	return nil
+}
+
+// addOpenTripCredits acrescenta ao saldo da viagem em andamento do veículo os créditos de uma análise cuja janela começa
+// em from; sem viagem em andamento, ou se a janela começa antes dela, nada muda. Como a leitura não enxerga escritas
+// da própria transação, deve ser chamada uma única vez por transação.
+func addOpenTripCredits(ctx contractapi.TransactionContextInterface, idcarro string, from int64, credits int) error {
+	if credits == 0 {
+		return nil
+	}
+	_, trip, err := getOpenTrip(ctx, idcarro)
+	if err != nil || trip == nil || from < trip.StartedAt {
+		return err
+	}
+	trip.NetCredits += credits
+	return putTrip(ctx, trip, nil)
+}
+
+// summarizeTrip calcula o resumo da viagem a partir das amostras, dos eventos e dos lançamentos entre from e to
+// e devolve também o sal da última amostra, ou nil se a viagem não tem amostras
+func summarizeTrip(ctx contractapi.TransactionContextInterface, trip *Trip, to int64) (*TripSummary, []byte, error) {
+	samples, err := getVehicleDataWindow(ctx, trip.VehicleID, trip.StartedAt, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	var salt []byte
+	if len(samples) > 0 {
+		salt, err = getSampleSalt(ctx, trip.VehicleID, samples[len(samples)-1].TimeStamp)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	summary := &TripSummary{Duration: to - trip.StartedAt, Distance: PathDistance(samples), SampleCount: len(samples)}
+	speedSum := 0.0
+	for _, sample := range samples {
+		if sample.Speed > summary.MaxSpeed {
+			summary.MaxSpeed = sample.Speed
+		}
+		speedSum += sample.Speed
+	}
+	if len(samples) > 0 {
+		summary.AverageSpeed = speedSum / float64(len(samples))
+	}
+
+	summary.EventCounts, summary.EventCount, err = countBehaviorEvents(ctx, trip.VehicleID, trip.StartedAt, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary.NetCredits = trip.NetCredits
+	return summary, salt, nil
+}
+
+// StartTrip inicia uma viagem do veículo a partir do timestamp (ms) da telemetria informado;
+// exige o proprietário, o dispositivo vinculado ou um administrador. O veículo não pode ter outra viagem em andamento.
+func (s *SmartContract) StartTrip(ctx contractapi.TransactionContextInterface, idcarro string, tripID string, startedAt int64) (*Trip, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if tripID == "" {
+		return nil, fmt.Errorf("identificador da viagem é obrigatório")
+	}
+	if startedAt < 0 {
+		return nil, fmt.Errorf("início da viagem %d não pode ser negativo", startedAt)
+	}
+	if err := requireTripControl(ctx, "StartTrip", idcarro); err != nil {
+		return nil, err
+	}
+
+	openKey, open, err := getOpenTrip(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if open != nil {
+		return nil, fmt.Errorf("veículo %s já está na viagem %s", idcarro, open.ID)
+	}
+	existing, err := ctx.GetStub().GetState(tripKey(idcarro, startedAt))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler a viagem: %s", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("já existe uma viagem do veículo %s iniciada em %d", idcarro, startedAt)
+	}
+
+	trip := &Trip{ID: tripID, VehicleID: idcarro, StartedAt: startedAt}
+	sessions, err := getSessionsStartedBetween(ctx, idcarro, startedAt-maxSessionDuration, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	trip.DriverID = driverAt(sessions, startedAt)
+
+	if err := putTrip(ctx, trip, nil); err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(openKey, []byte(tripKey(idcarro, startedAt))); err != nil {
+		return nil, fmt.Errorf("falha ao armazenar a viagem em andamento: %s", err)
+	}
+	return trip, nil
+}
+
+// EndTrip encerra a viagem em andamento no timestamp (ms) da telemetria informado e grava o seu resumo;
+// exige o proprietário, o dispositivo vinculado ou um administrador. Como a resposta fica registrada no bloco,
+// a viagem devolvida não traz a distância nem as velocidades, que podem ser consultadas em ListVehicleTrips.
+func (s *SmartContract) EndTrip(ctx contractapi.TransactionContextInterface, idcarro string, endedAt int64) (*Trip, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if err := requireTripControl(ctx, "EndTrip", idcarro); err != nil {
+		return nil, err
+	}
+
+	openKey, trip, err := getOpenTrip(ctx, idcarro)
+	if err != nil {
+		return nil, err
+	}
+	if trip == nil {
+		return nil, fmt.Errorf("veículo %s não está em uma viagem", idcarro)
+	}
+	if endedAt < trip.StartedAt {
+		return nil, fmt.Errorf("fim da viagem %d é anterior ao início %d", endedAt, trip.StartedAt)
+	}
+	if endedAt-trip.StartedAt > maxTripDuration {
+		return nil, fmt.Errorf("viagem de %d ms excede o limite de %d ms", endedAt-trip.StartedAt, maxTripDuration)
+	}
+
+	summary, salt, err := summarizeTrip(ctx, trip, endedAt)
+	if err != nil {
+		return nil, err
+	}
+	trip.Summary = summary
+	trip.EndedAt = endedAt
+	if err := putTrip(ctx, trip, salt); err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().DelState(openKey); err != nil {
+		return nil, fmt.Errorf("falha ao encerrar a viagem em andamento: %s", err)
+	}
+	return trip.public(), nil
+}
+
+// ListVehicleTrips consulta as viagens do veículo iniciadas entre from e to (inclusive), em ordem cronológica.
+// Exige consentimento para a categoria scores; a distância exige a categoria location e as velocidades, a categoria speed.
+func (s *SmartContract) ListVehicleTrips(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) ([]*Trip, error) {
+	if err := validateVehicleID(idcarro); err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(from, to); err != nil {
+		return nil, err
+	}
+	categories, err := requireConsent(ctx, "ListVehicleTrips", idcarro, CategoryScores)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(tripKey(idcarro, from), tripKey(idcarro, to+1))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as viagens do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	trips := []*Trip{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre as viagens do veículo: %s", err)
+		}
+
+		var trip Trip
+		err = json.Unmarshal(queryResponse.Value, &trip)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar a viagem: %s", err)
+		}
+		if err := loadTripSummaryDetail(ctx, &trip, categories); err != nil {
+			return nil, err
+		}
+		trips = append(trips, &trip)
+	}
+	return trips, nil
+}
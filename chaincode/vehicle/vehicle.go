@@ -18,19 +18,239 @@ type SmartContract struct {
 
 // VehicleData representa os dados do veículo
 type VehicleData struct { // pk: idcarro / placa do veiculo
-	Latitude  string `json:"latitude"`  // Mudança Brusca de Direção
-	Longitude string `json:"longitude"` // Mudança Brusca de Direção
-	Direction string `json:"direction"` // Mudança Brusca de Direção
-	Speed     string `json:"speed"`     // Detecção de Aceleração Anômala // Mudança Brusca de Direção
-	AccelX    string `json:"accelX"`    //zigue-zague
-	AccelY    string `json:"accelY"`    //zigue-zague
-	AccelZ    string `json:"accelZ"`    //zigue-zague // A aceleração em Z pode ser útil para detectar comportamentos relacionados a movimentos verticais // como subidas, descidas ou saltos, especialmente em terrenos irregulares.
-	TimeStamp string `json:"timestamp"` //Detecção de Aceleração Anômala
-	Flag      string `json:"flag"`      // controle de 10 em 10 linhas
+	Latitude  float64 `json:"latitude"`  // Mudança Brusca de Direção
+	Longitude float64 `json:"longitude"` // Mudança Brusca de Direção
+	Direction float64 `json:"direction"` // Mudança Brusca de Direção (rad)
+	Speed     float64 `json:"speed"`     // Detecção de Aceleração Anômala // Mudança Brusca de Direção (km/h)
+	AccelX    float64 `json:"accelX"`    //zigue-zague
+	AccelY    float64 `json:"accelY"`    //zigue-zague
+	AccelZ    float64 `json:"accelZ"`    //zigue-zague // A aceleração em Z pode ser útil para detectar comportamentos relacionados a movimentos verticais // como subidas, descidas ou saltos, especialmente em terrenos irregulares.
+	TimeStamp int64   `json:"timestamp"` //Detecção de Aceleração Anômala (unix, em milissegundos)
+
+	Redacted []string `json:"redacted,omitempty" metadata:"redacted,optional"` // categorias removidas por falta de consentimento
+}
+
+// Limites aceitos para uma leitura de telemetria
+const (
+	maxSpeed = 300.0 // km/h
+	maxAccel = 50.0  // m/s², em módulo, para cada eixo
+)
+
+// ParseVehicleData converte os argumentos recebidos como string em um VehicleData validado
+func ParseVehicleData(unixTimestamp string, latitudeStr string, longitudeStr string, speedStr string, accelXstr string, accelYstr string, accelZstr string) (*VehicleData, error) {
+	timestamp, err := strconv.ParseInt(unixTimestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp inválido %q: %s", unixTimestamp, err)
+	}
+
+	vehicleData := VehicleData{TimeStamp: timestamp}
+	for _, field := range []struct {
+		name  string
+		value string
+		dest  *float64
+	}{
+		{"latitude", latitudeStr, &vehicleData.Latitude},
+		{"longitude", longitudeStr, &vehicleData.Longitude},
+		{"velocidade", speedStr, &vehicleData.Speed},
+		{"aceleração X", accelXstr, &vehicleData.AccelX},
+		{"aceleração Y", accelYstr, &vehicleData.AccelY},
+		{"aceleração Z", accelZstr, &vehicleData.AccelZ},
+	} {
+		*field.dest, err = strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s inválida %q: %s", field.name, field.value, err)
+		}
+	}
+
+	if err := vehicleData.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &vehicleData, nil
+}
+
+// Validate verifica se os valores da leitura estão dentro de faixas plausíveis
+func (d *VehicleData) Validate() error {
+	if d.TimeStamp <= 0 || d.TimeStamp > maxTimestamp {
+		return fmt.Errorf("timestamp inválido: %d", d.TimeStamp)
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+		min   float64
+		max   float64
+	}{
+		{"latitude", d.Latitude, -90, 90},
+		{"longitude", d.Longitude, -180, 180},
+		{"direção", d.Direction, 0, 2 * math.Pi},
+		{"velocidade", d.Speed, 0, maxSpeed},
+		{"aceleração X", d.AccelX, -maxAccel, maxAccel},
+		{"aceleração Y", d.AccelY, -maxAccel, maxAccel},
+		{"aceleração Z", d.AccelZ, -maxAccel, maxAccel},
+	} {
+		// a comparação negada também rejeita NaN
+		if !(field.value >= field.min && field.value <= field.max) {
+			return fmt.Errorf("%s fora do intervalo [%v, %v]: %v", field.name, field.min, field.max, field.value)
+		}
+	}
+	return nil
 }
 
 type VehicleWallet struct { // pk: idcarro
-	Credits int `json:"credits"`
+	Credits  int `json:"credits"`
+	Sequence int `json:"sequence"` // sequência do próximo lançamento no extrato (CreditEntry)
+}
+
+// Prefixos das chaves do world state
+const (
+	walletIndex      = "WALLET"      // chave composta WALLET~idcarro
+	latestDataIndex  = "LATESTDATA"  // chave composta LATESTDATA~idcarro na coleção privada, última leitura do veículo
+	vehicleDataIndex = "VEHICLEDATA" // chave simples VEHICLEDATA~idcarro~timestamp, uma por amostra (privada e hash público)
+)
+
+// maxManualAdjustment limita, em valor absoluto, os créditos de um ajuste manual feito por GiveCredits
+const maxManualAdjustment = 1000000
+
+// maxTimestamp é o maior timestamp (ms) aceito em amostras e intervalos de consulta, 9999-12-31T23:59:59.999Z.
+// O limite mantém to+1 representável nas consultas por intervalo, cujo fim é exclusivo.
+const maxTimestamp int64 = 253402300799999
+
+// validateTimeRange verifica um intervalo de consulta [from, to] em milissegundos
+func validateTimeRange(from int64, to int64) error {
+	if from > to {
+		return fmt.Errorf("intervalo inválido: início %d é posterior ao fim %d", from, to)
+	}
+	if from < 0 || to > maxTimestamp {
+		return fmt.Errorf("intervalo inválido: use timestamps de 0 a %d", maxTimestamp)
+	}
+	return nil
+}
+
+// txTimestamp retorna o timestamp da transação em milissegundos, igual em todos os endossantes
+func txTimestamp(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("falha ao obter o timestamp da transação: %s", err)
+	}
+	return timestamp.GetSeconds()*1000 + int64(timestamp.GetNanos())/1000000, nil
+}
+
+// vehicleDataKey monta a chave de uma amostra de telemetria.
+// As amostras usam chave simples (e não composta) porque o GetStateByRange não aceita chaves
+// compostas; o timestamp é preenchido com zeros para que a ordem das chaves siga a ordem temporal.
+func vehicleDataKey(idcarro string, timestamp int64) string {
+	return fmt.Sprintf("%s~%s~%019d", vehicleDataIndex, idcarro, timestamp)
+}
+
+// validateVehicleID rejeita identificadores que quebrariam a ordenação das chaves por veículo
+func validateVehicleID(idcarro string) error {
+	if idcarro == "" {
+		return fmt.Errorf("identificador do veículo não informado")
+	}
+	if strings.Contains(idcarro, "~") {
+		return fmt.Errorf("identificador do veículo inválido %q: não pode conter '~'", idcarro)
+	}
+	return nil
+}
+
+// getLatestVehicleData recupera da coleção privada a última leitura do veículo, ou nil se ainda não houver dados
+func getLatestVehicleData(ctx contractapi.TransactionContextInterface, idcarro string) (*VehicleData, error) {
+	latestKey, err := ctx.GetStub().CreateCompositeKey(latestDataIndex, []string{idcarro})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a última leitura: %s", err)
+	}
+
+	vehicleDataJSON, err := ctx.GetStub().GetPrivateData(telemetryCollection, latestKey)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler os dados do veículo do ledger: %s", err)
+	}
+	if vehicleDataJSON == nil {
+		return nil, nil
+	}
+
+	var vehicleData VehicleData
+	err = json.Unmarshal(vehicleDataJSON, &vehicleData)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+	}
+
+	return &vehicleData, nil
+}
+
+// storeVehicleSamples grava cada amostra sob a sua própria chave na coleção privada, com o hash com sal no
+// world state público, atualiza a última leitura do veículo e avalia as amostras contra as cercas da frota do veículo.
+// As amostras precisam estar em ordem cronológica e ser posteriores à última leitura já armazenada.
+// Quando computeDirection é verdadeiro, a direção de cada amostra é calculada a partir da amostra anterior.
+func storeVehicleSamples(ctx contractapi.TransactionContextInterface, idcarro string, samples []*VehicleData, computeDirection bool, salt []byte) error {
+	if len(samples) == 0 {
+		return fmt.Errorf("nenhuma amostra informada para o veículo %s", idcarro)
+	}
+
+	// Recuperar dados anteriores para calcular a direção
+	previous, err := getLatestVehicleData(ctx, idcarro)
+	if err != nil {
+		return err
+	}
+
+	for _, vehicleData := range samples {
+		if previous != nil && vehicleData.TimeStamp <= previous.TimeStamp {
+			return fmt.Errorf("amostra fora de ordem para o veículo %s: timestamp %d não é posterior à leitura anterior (%d)", idcarro, vehicleData.TimeStamp, previous.TimeStamp)
+		}
+
+		// Se não há dados anteriores, a direção permanece 0
+		if computeDirection && previous != nil {
+			vehicleData.Direction = CalculateBearing(previous.Latitude, previous.Longitude, vehicleData.Latitude, vehicleData.Longitude)
+		}
+
+		if err := putPrivateVehicleData(ctx, idcarro, vehicleData, salt); err != nil {
+			return err
+		}
+
+		previous = vehicleData
+	}
+
+	latestJSON, err := json.Marshal(previous)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar os dados do veículo: %s", err)
+	}
+
+	latestKey, err := ctx.GetStub().CreateCompositeKey(latestDataIndex, []string{idcarro})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a última leitura: %s", err)
+	}
+
+	if err := ctx.GetStub().PutPrivateData(telemetryCollection, latestKey, latestJSON); err != nil {
+		return fmt.Errorf("falha ao armazenar a última leitura do veículo: %s", err)
+	}
+
+	return evaluateGeofences(ctx, idcarro, samples, salt)
+}
+
+// getVehicleDataWindow recupera da coleção privada, em ordem cronológica, as amostras do veículo com timestamp
+// entre from e to (inclusive)
+func getVehicleDataWindow(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) ([]VehicleData, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(telemetryCollection, vehicleDataKey(idcarro, from), vehicleDataKey(idcarro, to+1))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar as amostras do veículo: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	samples := []VehicleData{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao iterar sobre as amostras do veículo: %s", err)
+		}
+
+		var vehicleData VehicleData
+		err = json.Unmarshal(queryResponse.Value, &vehicleData)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+		}
+		samples = append(samples, vehicleData)
+	}
+
+	return samples, nil
 }
 
 // ConvertStringToFloatSlice converte uma string de números separados por espaço em um slice de float64
@@ -58,254 +278,401 @@ func ConvertStringToFloatSlice(data string) ([]float64, error) {
 // 	DetectAnomalousAcceleration()
 // )
 
-func (s *SmartContract) AnalyzeDriverBehavior(ctx contractapi.TransactionContextInterface, idcarro string) error {
-	// Recuperar o histórico de dados do veículo do ledger
-	// [BUG] Ele lê o próximo mesmo que não tenha nada
-	historyIterator, err := ctx.GetStub().GetHistoryForKey(idcarro)
+// AnalyzeDriverBehavior executa todos os detectores sobre as amostras do veículo com timestamp
+// entre from e to (inclusive, em milissegundos) e atualiza a carteira com o resultado.
+// Cada detector sem ocorrências rende RewardPerWindow créditos se o veículo esteve em movimento na janela, e a janela,
+// os eventos e os créditos são somados aos agregados diários consultados por GetDrivingScore. A distância percorrida
+// também é somada aos agregados, mas na coleção privada.
+// As janelas têm analysisWindow ms e começam em múltiplos de analysisWindow. As janelas de cada veículo são
+// analisadas uma única vez e em sequência (ver AnalysisCoverage): a janela deve começar depois da última analisada,
+// sem pular amostras, e terminar até a última amostra armazenada.
+func (s *SmartContract) AnalyzeDriverBehavior(ctx contractapi.TransactionContextInterface, idcarro string, from int64, to int64) error {
+	if err := validateVehicleID(idcarro); err != nil {
+		return err
+	}
+	if _, _, err := requireDevice(ctx, "AnalyzeDriverBehavior", idcarro, true); err != nil {
+		return err
+	}
+	coverage, err := getAnalysisCoverage(ctx, idcarro)
 	if err != nil {
-		return fmt.Errorf("falha ao obter histórico de dados do veículo: %s", err)
+		return err
+	}
+	if err := checkAnalysisWindow(ctx, coverage, from, to); err != nil {
+		return err
 	}
-	defer historyIterator.Close()
-
-	// Inicializar saldo
-	var saldo int
-	// Analisar cada registro histórico
-	speedSlice := []string{}
-	// timestampSlice := []string{}
-	accelXSlice := []string{}
-	accelYSlice := []string{}
-	accelZSlice := []string{}
-	flagSlice := []string{}
-	directionSlice := []string{}
-
-	// Iterar sobre o histórico e aplicar análises
-	for historyIterator.HasNext() {
-		historyEntry, err := historyIterator.Next()
-		if err != nil {
-			return fmt.Errorf("falha ao iterar sobre o histórico de dados do veículo: %s", err)
-		}
 
-		var historicalData VehicleData
-		err = json.Unmarshal(historyEntry.Value, &historicalData)
-		if err != nil {
-			return fmt.Errorf("falha ao desserializar dados históricos do veículo: %s", err)
-		}
+	// Recuperar as amostras do veículo dentro da janela
+	samples, err := getVehicleDataWindow(ctx, idcarro, from, to)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("nenhum dado encontrado para o veículo %s entre %d e %d", idcarro, from, to)
+	}
 
-		// speed, err := strconv.ParseFloat(historicalData.Speed, 32)
-		// log.Printf("Velocidade (MK1): %v", historicalData.Speed)
-		// if err != nil {
-		// 	if historicalData.Speed == "" {
-		// 		break
-		// 	} else {
-		// 		return fmt.Errorf("falha ao converter velocidade histórica: %s", err)
-		// 	}
-		// }
-		// timestamp, err := strconv.ParseInt(historicalData.TimeStamp, 10, 32)
-		// if err != nil {
-		// 	return fmt.Errorf("falha ao converter timestamp histórico: %s", err)
-		// }
-
-		flagSlice = append(flagSlice, historicalData.Flag)
-		speedSlice = append(speedSlice, historicalData.Speed)
-		// timestampSlice = append(timestampSlice, historicalData.TimeStamp)
-		directionSlice = append(directionSlice, historicalData.Direction)
-
-		// converter aceleração para float
-		// accelX, err := strconv.ParseFloat(historicalData.AccelX, 64)
-		// if err != nil {
-		// 	return fmt.Errorf("falha ao converter aceleração X histórica: %s", err)
-		// }
-		// accelY, err := strconv.ParseFloat(historicalData.AccelY, 64)
-		// if err != nil {
-		// 	return fmt.Errorf("falha ao converter aceleração Y histórica: %s", err)
-		// }
-		// accelZ, err := strconv.ParseFloat(historicalData.AccelZ, 64)
-		// if err != nil {
-		// 	return fmt.Errorf("falha ao converter aceleração Z histórica: %s", err)
-		// }
+	policy, err := getScoringPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	zones, err := listSpeedZones(ctx)
+	if err != nil {
+		return err
+	}
 
-		// append accel history to accelSlice
-		accelXSlice = append(accelXSlice, historicalData.AccelX)
-		accelYSlice = append(accelYSlice, historicalData.AccelY)
-		accelZSlice = append(accelZSlice, historicalData.AccelZ)
-		// fmt.Println(accelXSlice, accelYSlice, accelZSlice, flagSlice, speedSlice, directionSlice)
+	// Sessões de condução que podem cobrir a janela: os lançamentos atribuídos ao motorista vão para a carteira dele,
+	// e não para a do veículo
+	sessions, err := getSessionsStartedBetween(ctx, idcarro, from-maxSessionDuration, to)
+	if err != nil {
+		return err
+	}
+	rewardDriver := windowDriver(sessions, samples)
+	driverEntries := map[string][]*CreditEntry{}
 
-		// interrompe a execução após 10 registros
-		if len(speedSlice) == 10 {
-			break
+	// A distância ainda não contada vai para os agregados privados do dia da janela
+	day := dayOf(from)
+	distance, err := coveredDistance(ctx, coverage, samples)
+	if err != nil {
+		return err
+	}
+	reward := windowReward(policy.RewardPerWindow, samples)
+	vehicleDelta := newDrivingStats(idcarro, day)
+	vehicleDelta.Windows = 1
+	vehicleDelta.DistanceKm = distance
+	driverDeltas := map[string]*DrivingStats{}
+	driverDelta := func(driverID string) *DrivingStats {
+		if driverDeltas[driverID] == nil {
+			driverDeltas[driverID] = newDrivingStats(driverWalletID(driverID), day)
 		}
+		return driverDeltas[driverID]
+	}
+	if rewardDriver != "" {
+		driverDelta(rewardDriver).Windows = 1
+		driverDelta(rewardDriver).DistanceKm = distance
 	}
 
-	// Detectar zigue-zague se o primeiro flag for true
-	// serão executados a cada 10 linhas/segundos
-	if len(flagSlice) > 0 && flagSlice[0] == "true" {
-		credZigZag := DetectZigZag(accelXSlice, accelYSlice, accelZSlice)
-		credAnomalia, err := DetectAnomalousAcceleration(speedSlice)
+	// Inicializar saldo
+	var saldo int
+	// Analisar cada registro da janela, do mais antigo para o mais recente
+	speedSlice := []float64{}
+	timestampSlice := []int64{}
+	accelXSlice := []float64{}
+	accelYSlice := []float64{}
+	accelZSlice := []float64{}
+	latitudeSlice := []float64{}
+	longitudeSlice := []float64{}
+	limitSlice := []float64{}
+
+	for _, sample := range samples {
+		speedSlice = append(speedSlice, sample.Speed)
+		timestampSlice = append(timestampSlice, sample.TimeStamp)
+		latitudeSlice = append(latitudeSlice, sample.Latitude)
+		longitudeSlice = append(longitudeSlice, sample.Longitude)
+		limitSlice = append(limitSlice, speedLimitAt(zones, GeoPoint{Latitude: sample.Latitude, Longitude: sample.Longitude}))
+
+		// append accel history to accelSlice
+		accelXSlice = append(accelXSlice, sample.AccelX)
+		accelYSlice = append(accelYSlice, sample.AccelY)
+		accelZSlice = append(accelZSlice, sample.AccelZ)
+	}
+
+	detections := map[string]Detection{
+		EventZigZag:                DetectZigZag(accelXSlice, accelYSlice, accelZSlice, policy),
+		EventAnomalousAcceleration: DetectAnomalousAcceleration(timestampSlice, speedSlice, policy),
+		// Detectar curvas bruscas
+		EventSharpTurn: DetectSharpTurn(speedSlice, latitudeSlice, longitudeSlice, policy),
+		// Detectar excesso de velocidade nas zonas com limite
+		EventSpeeding: DetectSpeeding(timestampSlice, speedSlice, limitSlice, policy),
+	}
+
+	// Registrar cada detecção como um evento de comportamento e lançar os créditos de cada detector
+	events := []*BehaviorEvent{}
+	entries := []*CreditEntry{}
+	for _, eventType := range eventTypes {
+		detection := detections[eventType]
+		if !detection.Detected {
+			detection.Credits = reward
+		}
+		saldo += detection.Credits
+
+		if !detection.Detected {
+			entry := CreditEntry{
+				Amount:     detection.Credits,
+				Reason:     ReasonSafeDrivingReward,
+				Reference:  eventType,
+				WindowFrom: from,
+				WindowTo:   to,
+			}
+			// a recompensa vai para o motorista, e não para o veículo, se toda a janela pertencer à sua sessão
+			if rewardDriver != "" {
+				entry.VehicleID = idcarro
+				driverEntries[rewardDriver] = append(driverEntries[rewardDriver], &entry)
+				driverDelta(rewardDriver).Credits += entry.Amount
+			} else {
+				entries = append(entries, &entry)
+			}
+			continue
+		}
+		event := NewBehaviorEvent(ctx.GetStub().GetTxID(), idcarro, eventType, detection, samples[detection.Index], from, to)
+		event.DriverID = driverAt(sessions, event.TimeStamp)
+		salt, err := getSampleSalt(ctx, idcarro, event.TimeStamp)
 		if err != nil {
-			return fmt.Errorf("erro ao detectar aceleração anômala: %s", err)
+			return err
+		}
+		if err := putBehaviorEvent(ctx, event, salt); err != nil {
+			return err
+		}
+		events = append(events, event)
+		entry := CreditEntry{
+			Amount:     detection.Credits,
+			Reason:     ReasonBehaviorEvent,
+			EventID:    event.ID,
+			Reference:  eventType,
+			WindowFrom: from,
+			WindowTo:   to,
+		}
+		vehicleDelta.EventCount++
+		vehicleDelta.EventCounts[eventType]++
+		if event.DriverID != "" {
+			entry.VehicleID = idcarro
+			driverEntries[event.DriverID] = append(driverEntries[event.DriverID], &entry)
+			delta := driverDelta(event.DriverID)
+			delta.EventCount++
+			delta.EventCounts[eventType]++
+			delta.Credits += entry.Amount
+		} else {
+			entries = append(entries, &entry)
 		}
-		saldo += credZigZag
-		saldo += credAnomalia
 	}
-	// Detectar curvas bruscas
-	credCurva := DetectSharpTurn(speedSlice[0], directionSlice[0])
+	vehicleDelta.Credits = saldo
 
 	// Atualizar o saldo na carteira do cliente
-	walletKey, err := ctx.GetStub().CreateCompositeKey("WALLET", []string{idcarro})
+	vehicleWallet, err := postCreditEntries(ctx, idcarro, entries)
 	if err != nil {
-		return fmt.Errorf("erro ao criar chave composta para a carteira: %s", err)
+		return err
 	}
-
-	vehicleWalletAsBytes, err := ctx.GetStub().GetState(walletKey)
+	if err := postDriverCreditEntries(ctx, driverEntries); err != nil {
+		return err
+	}
+	lastSalt, err := getSampleSalt(ctx, idcarro, samples[len(samples)-1].TimeStamp)
 	if err != nil {
-		return fmt.Errorf("erro ao recuperar o saldo atual da carteira: %s", err)
+		return err
 	}
-
-	vehicleWallet := VehicleWallet{}
-	if vehicleWalletAsBytes != nil {
-		err = json.Unmarshal(vehicleWalletAsBytes, &vehicleWallet)
-		if err != nil {
-			return fmt.Errorf("falha ao desserializar a carteira do veículo: %s", err)
+	if err := addDrivingStats(ctx, idcarro, day, vehicleDelta, lastSalt); err != nil {
+		return err
+	}
+	for _, delta := range driverDeltas {
+		if err := addDrivingStats(ctx, delta.WalletID, day, delta, lastSalt); err != nil {
+			return err
 		}
 	}
+	if err := addOpenTripCredits(ctx, idcarro, from, saldo); err != nil {
+		return err
+	}
+	coverage.AnalyzedTo = to
+	coverage.LastSample = samples[len(samples)-1].TimeStamp
+	coverage.TxID = ctx.GetStub().GetTxID()
+	if err := putAnalysisCoverage(ctx, coverage); err != nil {
+		return err
+	}
 
-	saldo += credCurva
+	// Notificar os clientes: detecções têm prioridade sobre a simples atualização de saldo
+	if len(events) > 0 {
+		return emitEvent(ctx, EventNameBehaviorDetected, BehaviorDetectedPayload{
+			VehicleID:   idcarro,
+			Events:      publicBehaviorEvents(events),
+			CreditDelta: saldo,
+			Balance:     vehicleWallet.Credits,
+		})
+	}
+	credited := 0
+	for _, entry := range entries {
+		credited += entry.Amount
+	}
+	return emitEvent(ctx, EventNameWalletCredited, WalletCreditedPayload{
+		VehicleID: idcarro,
+		Amount:    credited,
+		Balance:   vehicleWallet.Credits,
+	})
+}
 
-	vehicleWallet.Credits += saldo
+// StoreVehicleData armazena os dados do veículo na coleção privada, com o hash com sal no ledger público.
+// Os campos timestamp, latitude, longitude, speed, accelX, accelY, accelZ, signature e salt são lidos do mapa transiente.
+// signature é a assinatura do dispositivo sobre TelemetryMessage(idcarro, timestamp, latitude, longitude, speed, accelX, accelY, accelZ).
+func (s *SmartContract) StoreVehicleData(ctx contractapi.TransactionContextInterface, idcarro string) error {
+	if err := validateVehicleID(idcarro); err != nil {
+		return err
+	}
+	_, vehicle, err := requireDevice(ctx, "StoreVehicleData", idcarro, false)
+	if err != nil {
+		return err
+	}
+	fields, err := getTransientFields(ctx, "timestamp", "latitude", "longitude", "speed", "accelX", "accelY", "accelZ", transientSignature)
+	if err != nil {
+		return err
+	}
+	unixTimestamp, latitudeStr, longitudeStr, speedStr, accelXstr, accelYstr, accelZstr, signature := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7]
+	salt, err := getTransientSalt(ctx)
+	if err != nil {
+		return err
+	}
+	message := TelemetryMessage(idcarro, unixTimestamp, latitudeStr, longitudeStr, speedStr, accelXstr, accelYstr, accelZstr)
+	if err := verifyTelemetrySignature(vehicle, message, signature); err != nil {
+		return err
+	}
 
-	vehicleWalletJSON, err := json.Marshal(vehicleWallet)
+	vehicleData, err := ParseVehicleData(unixTimestamp, latitudeStr, longitudeStr, speedStr, accelXstr, accelYstr, accelZstr)
 	if err != nil {
-		return fmt.Errorf("falha ao serializar a carteira do veículo: %s", err)
+		return fmt.Errorf("dados do veículo inválidos: %s", err)
 	}
 
-	// Salvar o registro atual no ledger
-	return ctx.GetStub().PutState(walletKey, vehicleWalletJSON)
+	// Armazenar os dados no ledger, calculando a direção a partir da leitura anterior
+	return storeVehicleSamples(ctx, idcarro, []*VehicleData{vehicleData}, true, salt)
 }
 
-// StoreVehicleData armazena os dados do veículo no ledger
-func (s *SmartContract) StoreVehicleData(ctx contractapi.TransactionContextInterface, idcarro string, unixTimestamp string, latitudeStr string, longitudeStr string, speedStr string, accelXstr string, accelYstr string, accelZstr string, flag string) error {
-	// Recuperar dados anteriores para calcular a direção
-	previousDataJSON, err := ctx.GetStub().GetState(idcarro)
-	if err != nil {
-		return fmt.Errorf("falha ao ler os dados do veículo do ledger: %s", err)
+// TelemetrySample é o formato de cada amostra recebida por StoreVehicleDataBatch.
+// Os campos são ponteiros para que a ausência de um campo obrigatório seja detectada.
+type TelemetrySample struct {
+	TimeStamp *int64   `json:"timestamp"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Speed     *float64 `json:"speed"`
+	AccelX    *float64 `json:"accelX"`
+	AccelY    *float64 `json:"accelY"`
+	AccelZ    *float64 `json:"accelZ"`
+}
+
+// maxBatchSize limita o número de amostras por transação de lote
+const maxBatchSize = 500
+
+// ParseTelemetryBatch converte um array JSON de amostras em leituras validadas
+func ParseTelemetryBatch(samplesJSON string) ([]*VehicleData, error) {
+	decoder := json.NewDecoder(strings.NewReader(samplesJSON))
+	decoder.DisallowUnknownFields()
+
+	var batch []TelemetrySample
+	if err := decoder.Decode(&batch); err != nil {
+		return nil, fmt.Errorf("lote de amostras inválido: %s", err)
+	}
+	if len(batch) == 0 {
+		return nil, fmt.Errorf("lote de amostras vazio")
+	}
+	if len(batch) > maxBatchSize {
+		return nil, fmt.Errorf("lote com %d amostras excede o limite de %d", len(batch), maxBatchSize)
 	}
 
-	if previousDataJSON != nil {
-		var previousVehicleData VehicleData
-		err = json.Unmarshal(previousDataJSON, &previousVehicleData)
-		if err != nil {
-			return fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+	samples := make([]*VehicleData, 0, len(batch))
+	for i, sample := range batch {
+		if sample.TimeStamp == nil || sample.Latitude == nil || sample.Longitude == nil || sample.Speed == nil ||
+			sample.AccelX == nil || sample.AccelY == nil || sample.AccelZ == nil {
+			return nil, fmt.Errorf("amostra %d incompleta: timestamp, latitude, longitude, speed, accelX, accelY e accelZ são obrigatórios", i)
 		}
 
-		// Calcular a direção
-		latitude, err := strconv.ParseFloat(latitudeStr, 64)
-		if err != nil {
-			return fmt.Errorf("falha ao converter latitude: %s", err)
-		}
-		longitude, err := strconv.ParseFloat(longitudeStr, 64)
-		if err != nil {
-			return fmt.Errorf("falha ao converter longitude: %s", err)
+		vehicleData := &VehicleData{
+			Latitude:  *sample.Latitude,
+			Longitude: *sample.Longitude,
+			Speed:     *sample.Speed,
+			AccelX:    *sample.AccelX,
+			AccelY:    *sample.AccelY,
+			AccelZ:    *sample.AccelZ,
+			TimeStamp: *sample.TimeStamp,
 		}
-		previousLatitude, err := strconv.ParseFloat(previousVehicleData.Latitude, 64)
-		if err != nil {
-			return fmt.Errorf("falha ao converter latitude anterior: %s", err)
-		}
-		previousLongitude, err := strconv.ParseFloat(previousVehicleData.Longitude, 64)
-		if err != nil {
-			return fmt.Errorf("falha ao converter longitude anterior: %s", err)
-		}
-		direction := CalculateBearing(previousLatitude, previousLongitude, latitude, longitude)
-		// Criar a estrutura VehicleData
-		vehicleData := VehicleData{
-			Latitude:  latitudeStr,
-			Longitude: longitudeStr,
-			Direction: fmt.Sprintf("%f", direction),
-			Speed:     speedStr,
-			AccelX:    accelXstr,
-			AccelY:    accelYstr,
-			AccelZ:    accelZstr,
-			TimeStamp: unixTimestamp,
-			Flag:      flag,
-		}
-
-		// Armazenar os dados no ledger
-		vehicleDataJSON, err := json.Marshal(vehicleData)
-		if err != nil {
-			return fmt.Errorf("falha ao serializar os dados do veículo: %s", err)
+		if err := vehicleData.Validate(); err != nil {
+			return nil, fmt.Errorf("amostra %d inválida: %s", i, err)
 		}
+		samples = append(samples, vehicleData)
+	}
 
-		return ctx.GetStub().PutState(idcarro, vehicleDataJSON)
-		// if err != nil {
-		// 	return fmt.Errorf("falha ao armazenar os dados do veículo no ledger: %s", err)
-		// }
+	return samples, nil
+}
 
+// StoreVehicleDataBatch valida e armazena de uma só vez um array JSON de amostras do veículo na coleção privada.
+// Se qualquer amostra for inválida, nenhuma é gravada. Os campos samples, signature e salt são lidos do mapa transiente.
+// signature é a assinatura do dispositivo sobre TelemetryMessage(idcarro, samples).
+func (s *SmartContract) StoreVehicleDataBatch(ctx contractapi.TransactionContextInterface, idcarro string) error {
+	if err := validateVehicleID(idcarro); err != nil {
+		return err
 	}
-	// ELSE
-	// Se não há dados anteriores, armazenar apenas a latitude e longitude
-	newVehicleData := VehicleData{
-		Latitude:  latitudeStr,
-		Longitude: longitudeStr,
-		Direction: "0", // inicialmente, a direção pode ser 0
-		Speed:     speedStr,
-		AccelX:    accelXstr,
-		AccelY:    accelYstr,
-		AccelZ:    accelZstr,
-		TimeStamp: unixTimestamp,
-		Flag:      flag,
+	_, vehicle, err := requireDevice(ctx, "StoreVehicleDataBatch", idcarro, false)
+	if err != nil {
+		return err
 	}
-
-	// Armazenar os dados no ledger
-	newVehicleDataJSON, err := json.Marshal(newVehicleData)
+	fields, err := getTransientFields(ctx, "samples", transientSignature)
 	if err != nil {
-		return fmt.Errorf("falha ao serializar os dados do veículo: %s", err)
+		return err
+	}
+	samplesJSON, signature := fields[0], fields[1]
+	salt, err := getTransientSalt(ctx)
+	if err != nil {
+		return err
+	}
+	if err := verifyTelemetrySignature(vehicle, TelemetryMessage(idcarro, samplesJSON), signature); err != nil {
+		return err
 	}
 
-	return ctx.GetStub().PutState(idcarro, newVehicleDataJSON)
-	// if err != nil {
-	// 	return fmt.Errorf("falha ao armazenar os dados do veículo no ledger: %s", err)
-	// }
+	samples, err := ParseTelemetryBatch(samplesJSON)
+	if err != nil {
+		return err
+	}
 
-	// return nil
+	return storeVehicleSamples(ctx, idcarro, samples, true, salt)
 }
 
-// StoreSimpleVehicleData armazena os dados do veículo no ledger sem verificação extra, f
-func (s *SmartContract) StoreSimpleVehicleData(ctx contractapi.TransactionContextInterface, idcarro string, unixTimestamp string, latitudeStr string, longitudeStr string, speedStr, direction string, accelXstr string, accelYstr string, accelZstr string, flag string) error {
-	vehicleData := VehicleData{
-		Latitude:  latitudeStr,
-		Longitude: longitudeStr,
-		Direction: direction,
-		Speed:     speedStr,
-		AccelX:    accelXstr,
-		AccelY:    accelYstr,
-		AccelZ:    accelZstr,
-		TimeStamp: unixTimestamp,
-		Flag:      flag,
+// StoreSimpleVehicleData armazena os dados do veículo na coleção privada sem calcular a direção, que é informada pelo cliente.
+// Os campos timestamp, latitude, longitude, speed, direction, accelX, accelY, accelZ, signature e salt são lidos do mapa transiente.
+// signature é a assinatura do dispositivo sobre TelemetryMessage(idcarro, timestamp, latitude, longitude, speed, direction, accelX, accelY, accelZ).
+func (s *SmartContract) StoreSimpleVehicleData(ctx contractapi.TransactionContextInterface, idcarro string) error {
+	if err := validateVehicleID(idcarro); err != nil {
+		return err
+	}
+	_, vehicle, err := requireDevice(ctx, "StoreSimpleVehicleData", idcarro, false)
+	if err != nil {
+		return err
+	}
+	fields, err := getTransientFields(ctx, "timestamp", "latitude", "longitude", "speed", "direction", "accelX", "accelY", "accelZ", transientSignature)
+	if err != nil {
+		return err
+	}
+	unixTimestamp, latitudeStr, longitudeStr, speedStr, direction, accelXstr, accelYstr, accelZstr, signature := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7], fields[8]
+	salt, err := getTransientSalt(ctx)
+	if err != nil {
+		return err
+	}
+	message := TelemetryMessage(idcarro, unixTimestamp, latitudeStr, longitudeStr, speedStr, direction, accelXstr, accelYstr, accelZstr)
+	if err := verifyTelemetrySignature(vehicle, message, signature); err != nil {
+		return err
 	}
 
-	vehicleDataJSON, err := json.Marshal(vehicleData)
+	vehicleData, err := ParseVehicleData(unixTimestamp, latitudeStr, longitudeStr, speedStr, accelXstr, accelYstr, accelZstr)
 	if err != nil {
-		return fmt.Errorf("falha ao serializar os dados do veículo: %s", err)
+		return fmt.Errorf("dados do veículo inválidos: %s", err)
+	}
+
+	vehicleData.Direction, err = strconv.ParseFloat(direction, 64)
+	if err != nil {
+		return fmt.Errorf("dados do veículo inválidos: direção inválida %q: %s", direction, err)
+	}
+	if err := vehicleData.Validate(); err != nil {
+		return fmt.Errorf("dados do veículo inválidos: %s", err)
 	}
 
-	return ctx.GetStub().PutState(idcarro, vehicleDataJSON)
+	return storeVehicleSamples(ctx, idcarro, []*VehicleData{vehicleData}, false, salt)
 }
 
 // InitVehicleWallet inicializa uma carteira de veículo com quantidade inicial de créditos 0
 func (s *SmartContract) CreateVehicleWallet(ctx contractapi.TransactionContextInterface, idcarro string) error {
-	// verifique se a carteira já existe
-	indexName := "WALLET"
-	compositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{idcarro})
-	if err != nil {
+	if err := validateVehicleID(idcarro); err != nil {
+		return err
+	}
+	if _, _, err := requireVehicleOwner(ctx, "CreateVehicleWallet", idcarro, true); err != nil {
 		return err
 	}
 
-	// verificação de integridade referencial
-	vehicleWalletAsBytes, err := ctx.GetStub().GetState(compositeKey)
+	// verifique se a carteira já existe
+	existingWallet, err := getWallet(ctx, idcarro)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %s", err)
+		return err
 	}
-
-	if vehicleWalletAsBytes != nil {
+	if existingWallet != nil {
 		return fmt.Errorf("carteira já existe para o veiculo %s", idcarro)
 	}
 
@@ -313,104 +680,66 @@ func (s *SmartContract) CreateVehicleWallet(ctx contractapi.TransactionContextIn
 		Credits: 0,
 	}
 
-	vehicleWalletJSON, err := json.Marshal(vehicleWallet)
-	if err != nil {
-		return fmt.Errorf("falha ao serializar a carteira do veículo: %s", err)
+	if err := putWallet(ctx, idcarro, &vehicleWallet); err != nil {
+		return err
 	}
 
-	return ctx.GetStub().PutState(compositeKey, vehicleWalletJSON)
+	return emitEvent(ctx, EventNameWalletCreated, WalletCreatedPayload{VehicleID: idcarro, Balance: vehicleWallet.Credits})
 }
 
 // QueryVehicleWallet consulta a carteira do veículo armazenada no ledger
 func (s *SmartContract) QueryVehicleWallet(ctx contractapi.TransactionContextInterface, idcarro string) (*VehicleWallet, error) {
-	indexName := "WALLET"
-	compositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{idcarro})
-	if err != nil {
+	if err := requireWalletRead(ctx, "QueryVehicleWallet", idcarro); err != nil {
 		return nil, err
 	}
 
-	vehicleWalletAsBytes, err := ctx.GetStub().GetState(compositeKey)
+	vehicleWallet, err := getWallet(ctx, idcarro)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %s", err)
+		return nil, err
 	}
 
-	if vehicleWalletAsBytes == nil {
+	if vehicleWallet == nil {
 		return nil, fmt.Errorf("carteira do veículo não encontrada")
 	}
 
-	var vehicleWallet VehicleWallet
-	err = json.Unmarshal(vehicleWalletAsBytes, &vehicleWallet)
-	if err != nil {
-		return nil, fmt.Errorf("falha ao desserializar a carteira do veículo: %s", err)
-	}
-
 	log.Printf("Créditos: %v", vehicleWallet.Credits)
 	// repeat string
 	// fmt.Println(strings.Repeat("=", 10))
 
-	return &vehicleWallet, nil
+	return vehicleWallet, nil
 }
 
-func (s *SmartContract) TestRichQuery(ctx contractapi.TransactionContextInterface, query string) error {
-	queryString := fmt.Sprintf(`{"selector":{"timestamp":"%s"}}`, query) // ABC4444
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-
-	if err != nil {
-		return fmt.Errorf("falha ao consultar o ledger: %s", err)
-	}
-
-	defer resultsIterator.Close()
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return fmt.Errorf("falha ao iterar sobre os resultados da consulta: %s", err)
-		}
-
-		var vehicleData VehicleData
-		err = json.Unmarshal(queryResponse.Value, &vehicleData)
-		if err != nil {
-			return fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
-		}
-
-		// log.Println("Registro: ", &vehicleData)
-	}
-
-	return nil
+// Detection é o resultado de um detector sobre uma janela de amostras
+type Detection struct {
+	Detected  bool
+	Credits   int     // penalidade aplicada à carteira quando Detected; a recompensa por distância é calculada na análise
+	Index     int     // posição, na janela, da amostra que disparou a detecção
+	Measured  float64 // valor observado que disparou a detecção
+	Threshold float64 // limite configurado para o valor observado
 }
 
 // DetectAnalousAcceleration verifica a anomalia e atualiza a carteira do veículo de acordo
-func DetectAnomalousAcceleration(speedSlice []string) (int, error) {
+func DetectAnomalousAcceleration(timestampSlice []int64, speedSlice []float64, policy *ScoringPolicy) Detection {
 
 	// Calcular aceleração anômala
-	anomalous := false
-	credits := 10
-
-	// calcula o delta de velocidade e tempo entre o tempo final - o de [5 segundos atrás]
-	// deltaSpeed := math.Abs(speedSlice[i] - speedSlice[i-1])
-	// deltaTime := timestampSlice[i] - timestampSlice[i-1]
-
-	tamanho := len(speedSlice)
-	valorfinal, err := strconv.ParseFloat(speedSlice[tamanho-1], 32)
-	if err != nil {
-		return 0, fmt.Errorf("erro ao converter valor para float64: %v", err.Error()) // or handle the error appropriately
-	}
-	valorinicial, err := strconv.ParseFloat(speedSlice[0], 32)
-	if err != nil {
-		return 0, fmt.Errorf("erro ao converter valor para float64: %v", err.Error()) // or handle the error appropriately
-	}
-
-	deltaSpeed := math.Abs(valorfinal - valorinicial)
-
-	// Se a variação de velocidade for maior que 30 km/h em menos de 5 segundos
-	if deltaSpeed > 30 {
-		anomalous = true
-		credits = -50
+	detection := Detection{Threshold: policy.AnomalySpeedDelta}
+
+	// compara cada amostra com as amostras do intervalo anterior (padrão: 5 segundos)
+	for j := 1; j < len(speedSlice) && !detection.Detected; j++ {
+		for i := j - 1; i >= 0 && timestampSlice[j]-timestampSlice[i] <= policy.AnomalyInterval; i-- {
+			deltaSpeed := math.Abs(speedSlice[j] - speedSlice[i])
+
+			// Se a variação de velocidade for maior que o limite (padrão: 30 km/h em menos de 5 segundos)
+			if deltaSpeed > detection.Threshold {
+				detection = Detection{Detected: true, Credits: policy.AnomalyPenalty, Index: j, Measured: deltaSpeed, Threshold: detection.Threshold}
+				break
+			}
+		}
 	}
 
-	log.Print("Anomalia: ", anomalous)
+	log.Print("Anomalia: ", detection.Detected)
 
-	return credits, nil
+	return detection
 }
 
 // Função para detectar comportamento de zigue-zague
@@ -419,83 +748,133 @@ func DetectAnomalousAcceleration(speedSlice []string) (int, error) {
 // então, comparar segundo[9] com segundo [8] OU com segundo[9] com segundo[7]
 // ex: comparar o sinal atual com o de 2 segundos antes
 
-func DetectZigZag(accelXSlice []string, accelYSlice []string, accelZSlice []string) int {
-	// Cria a chave composta para a carteira
+func DetectZigZag(accelXSlice []float64, accelYSlice []float64, accelZSlice []float64, policy *ScoringPolicy) Detection {
 	// Variáveis para comparação e contagem de zigue-zague
 	var zigzagCount int
-	credits := 10      // Define o valor da penalização ou recompensa
-	detection := false // Define se houve zigue-zague
+	detection := Detection{Threshold: float64(policy.ZigZagMinCount)}
 
-	// ele vai ler de tras para frente (do mais antigo até o mais recente)
-	for i := len(accelXSlice) - 1; i > 0; i-- {
+	// lê do mais antigo até o mais recente
+	for i := 1; i < len(accelXSlice); i++ {
 		// Recupera últimos valores de aceleração para detectar zigue-zague
 
 		// parametros removidos
 		// currentAccelX := accelXSlice[i]
-		// nextAccelX := accelXSlice[i-1]
-		// nextAccelY := accelYSlice[i-1]
+		// previousAccelX := accelXSlice[i-1]
+		// previousAccelY := accelYSlice[i-1]
 
-		currentAccelY, err := strconv.ParseFloat(accelYSlice[i], 64)
-		if err != nil {
-			log.Printf("Erro ao converter aceleração Y: %v", err)
-			continue
-		}
+		currentAccelY := accelYSlice[i]
 		currentAccelZ := accelZSlice[i]
-		nextAccelZ := accelZSlice[i-1]
+		previousAccelZ := accelZSlice[i-1]
 
 		// Compara os valores para detectar zigue-zague
-		if currentAccelY >= 0.0080 && currentAccelZ != nextAccelZ {
+		if currentAccelY >= policy.ZigZagAccelY && currentAccelZ != previousAccelZ {
 			zigzagCount++
+			// a amostra que completa o número mínimo de zigue-zagues marca o evento
+			if zigzagCount == policy.ZigZagMinCount {
+				detection.Index = i
+			}
 		}
 	}
 
-	// Se o número de zigue-zagues for maior ou igual a 3, aplica penalização
-	if zigzagCount >= 3 {
+	// Se o número de zigue-zagues for maior ou igual ao mínimo (padrão: 3), aplica penalização
+	if zigzagCount >= policy.ZigZagMinCount {
 		// Aplique penalização na carteira do veículo
-		credits = -40
-		detection = true
+		detection.Detected = true
+		detection.Credits = policy.ZigZagPenalty
+		detection.Measured = float64(zigzagCount)
 	}
 
 	// Caso contrário, o veículo está dirigindo de forma aceitável
-	log.Printf("Zigue-zague: %v", detection)
+	log.Printf("Zigue-zague: %v", detection.Detected)
 
-	return credits
+	return detection
 }
 
-// Função para detectar mudanças bruscas de direção
-func DetectSharpTurn(speed string, direction string) int {
-	credits := 0
-	flag := false
+// minTurnSegment é o menor deslocamento (km) entre amostras usado para calcular a direção; abaixo disso,
+// o ruído do GPS produziria mudanças de direção sem significado
+const minTurnSegment = 0.002
 
-	// Conversão da direção para float
-	directionFloat, err := strconv.ParseFloat(direction, 64)
-	if err != nil {
-		fmt.Println("Erro ao converter direção:", err)
+// Função para detectar mudanças bruscas de direção em qualquer amostra da janela: compara a direção de cada trecho
+// entre amostras consecutivas com a do trecho anterior, e não a direção absoluta do veículo
+func DetectSharpTurn(speedSlice []float64, latitudeSlice []float64, longitudeSlice []float64, policy *ScoringPolicy) Detection {
+	detection := Detection{Threshold: policy.SharpTurnDirection}
+
+	previousBearing := math.NaN()
+	for i := 1; i < len(speedSlice); i++ {
+		// Trechos muito curtos (veículo parado) não definem direção
+		if Haversine(latitudeSlice[i-1], longitudeSlice[i-1], latitudeSlice[i], longitudeSlice[i]) < minTurnSegment {
+			continue
+		}
+		bearing := CalculateBearing(latitudeSlice[i-1], longitudeSlice[i-1], latitudeSlice[i], longitudeSlice[i])
+		if math.IsNaN(previousBearing) {
+			previousBearing = bearing
+			continue
+		}
+
+		// Mudança de direção entre os trechos, de 0 a π
+		change := math.Abs(bearing - previousBearing)
+		if change > math.Pi {
+			change = 2*math.Pi - change
+		}
+		previousBearing = bearing
+
+		// Se a mudança for maior que o limite (padrão: 0.7 rad) e a velocidade maior que o limite (padrão: 30 km/h)
+		if change > policy.SharpTurnDirection && speedSlice[i] > policy.SharpTurnSpeed {
+			// Penalidade (padrão: -30 créditos)
+			detection = Detection{Detected: true, Credits: policy.SharpTurnPenalty, Index: i, Measured: change, Threshold: detection.Threshold}
+			break
+		}
 	}
 
-	// debug
-	// log.Printf("Direção: %v", direction)
-	// log.Printf("Velocidade (MK2): %v", speed)
+	log.Printf("Curva brusca: %v.", detection.Detected)
+	return detection
+}
+
+// speedingSampleDuration é a duração atribuída a uma amostra sem vizinhas na janela (1 Hz, como no conjunto de dados)
+const speedingSampleDuration = 1000
 
-	//converter
-	speedFloat, err := strconv.ParseFloat(speed, 64)
-	if err != nil {
-		fmt.Println("Erro ao converter velocidade:", err)
+// maxSpeedingSampleGap limita a duração atribuída a uma amostra, para que falhas na telemetria não prolonguem o excesso
+const maxSpeedingSampleGap = 10 * 1000
+
+// DetectSpeeding verifica as amostras com velocidade acima do limite da zona em que estavam (limitSlice, zero fora
+// de zonas) mais a tolerância. A penalidade cresce com o excesso e com a duração: cada amostra acima do limite conta
+// o excesso em km/h pelo tempo até a amostra seguinte. O evento marca a amostra de maior excesso.
+func DetectSpeeding(timestampSlice []int64, speedSlice []float64, limitSlice []float64, policy *ScoringPolicy) Detection {
+	detection := Detection{}
+	maxExcess := 0.0
+	excessMinutes := 0.0 // soma de km/h acima do limite x minutos
+
+	for i, speed := range speedSlice {
+		limit := limitSlice[i]
+		if limit == 0 || speed <= limit+policy.SpeedingTolerance {
+			continue
+		}
+
+		excess := speed - limit
+		if excess > maxExcess {
+			maxExcess = excess
+			detection = Detection{Detected: true, Index: i, Measured: speed, Threshold: limit}
+		}
+
+		duration := int64(speedingSampleDuration)
+		switch {
+		case i+1 < len(timestampSlice):
+			duration = timestampSlice[i+1] - timestampSlice[i]
+		case i > 0:
+			duration = timestampSlice[i] - timestampSlice[i-1]
+		}
+		if duration > maxSpeedingSampleGap {
+			duration = maxSpeedingSampleGap
+		}
+		excessMinutes += excess * float64(duration) / 60000
 	}
 
-	// Se a direção for maior que 0.7 rad e a velocidade maior que 30 km/h
-	if directionFloat == 0 {
-		log.Printf("Direção neutra")
-		credits += 10
-	} else if directionFloat < 0.7 && speedFloat > 30 {
-		credits -= 30 // Penalidade de -30 créditos
-		flag = true
-	} else {
-		credits += 10
+	if detection.Detected {
+		detection.Credits = int(math.Floor(policy.SpeedingPenaltyRate * excessMinutes))
 	}
 
-	log.Printf("Curva brusca: %v.", flag)
-	return credits
+	log.Printf("Excesso de velocidade: %v", detection.Detected)
+	return detection
 }
 
 // CalculateBearing calcula a direção entre dois pontos geográficos
@@ -514,25 +893,46 @@ func CalculateBearing(lat1, lon1, lat2, lon2 float64) float64 {
 	return bearing
 }
 
+// earthRadiusKm é o raio médio da Terra usado por Haversine
+const earthRadiusKm = 6371.0
+
+// Haversine calcula a distância, em quilômetros, entre dois pontos geográficos
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	deltaLat := (lat2 - lat1) * math.Pi / 180
+	deltaLon := (lon2 - lon1) * math.Pi / 180
 
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
 
-// QueryVehicleData consulta os dados do veículo armazenados no ledger
+// PathDistance soma, em quilômetros, as distâncias entre amostras consecutivas
+func PathDistance(samples []VehicleData) float64 {
+	distance := 0.0
+	for i := 1; i < len(samples); i++ {
+		distance += Haversine(samples[i-1].Latitude, samples[i-1].Longitude, samples[i].Latitude, samples[i].Longitude)
+	}
+	return distance
+}
+
+// QueryVehicleData consulta a última leitura do veículo armazenada no ledger;
+// exige o proprietário, um administrador ou uma compra de dados válida que cubra a leitura.
+// Campos sem consentimento do proprietário para a organização do chamador são removidos.
 func (s *SmartContract) QueryVehicleData(ctx contractapi.TransactionContextInterface, idcarro string) (*VehicleData, error) {
-	vehicleDataJSON, err := ctx.GetStub().GetState(idcarro)
+	vehicleData, err := getLatestVehicleData(ctx, idcarro)
 	if err != nil {
-		return nil, fmt.Errorf("falha ao ler os dados do veículo do ledger: %s", err)
+		return nil, err
 	}
-	if vehicleDataJSON == nil {
+	if vehicleData == nil {
 		return nil, fmt.Errorf("dados do veículo não encontrados")
 	}
-
-	var vehicleData VehicleData
-	err = json.Unmarshal(vehicleDataJSON, &vehicleData)
+	categories, err := requireDataAccess(ctx, "QueryVehicleData", idcarro, vehicleData.TimeStamp, vehicleData.TimeStamp)
 	if err != nil {
-		return nil, fmt.Errorf("falha ao desserializar os dados do veículo: %s", err)
+		return nil, err
 	}
+	vehicleData.Redact(categories)
 
-	return &vehicleData, nil
+	return vehicleData, nil
 }
 
 // // QueryVehicleAnomaly consulta os dados de anomalia do veículo armazenados no ledger
@@ -553,36 +953,33 @@ func (s *SmartContract) QueryVehicleData(ctx contractapi.TransactionContextInter
 // 	return &anomalyResult, nil
 // }
 
+// GiveCredits concede (ou, com valor negativo, retira) créditos da carteira do veículo; exige role=insurer ou role=admin.
+// Como em TransferCredits, uma retirada não pode deixar a carteira com saldo negativo.
 func (s *SmartContract) GiveCredits(ctx contractapi.TransactionContextInterface, idcarro string, credits int) error {
-	indexName := "WALLET"
-	compositeKey, err := ctx.GetStub().CreateCompositeKey(indexName, []string{idcarro})
-	if err != nil {
-		return fmt.Errorf("erro ao criar chave composta para a carteira: %s", err)
+	if _, err := requireRole(ctx, "GiveCredits", RoleInsurer, RoleAdmin); err != nil {
+		return err
+	}
+	if credits == 0 || credits < -maxManualAdjustment || credits > maxManualAdjustment {
+		return fmt.Errorf("quantidade de créditos inválida %d: use de %d a %d, diferente de zero", credits, -maxManualAdjustment, maxManualAdjustment)
 	}
 
-	vehicleWalletAsBytes, err := ctx.GetStub().GetState(compositeKey)
+	existingWallet, err := getWallet(ctx, idcarro)
 	if err != nil {
-		return fmt.Errorf("erro ao recuperar o saldo atual da carteira: %s", err)
+		return err
 	}
-
-	if vehicleWalletAsBytes == nil {
+	if existingWallet == nil {
 		return fmt.Errorf("carteira do veículo não encontrada")
 	}
-
-	var vehicleWallet VehicleWallet
-	err = json.Unmarshal(vehicleWalletAsBytes, &vehicleWallet)
-	if err != nil {
-		return fmt.Errorf("falha ao desserializar a carteira do veículo: %s", err)
+	if credits < 0 && existingWallet.Credits < -credits {
+		return fmt.Errorf("saldo insuficiente na carteira %s: %d créditos disponíveis, %d solicitados", idcarro, existingWallet.Credits, -credits)
 	}
 
-	vehicleWallet.Credits += credits
-
-	vehicleWalletJSON, err := json.Marshal(vehicleWallet)
+	vehicleWallet, err := postCreditEntries(ctx, idcarro, []*CreditEntry{{Amount: credits, Reason: ReasonManualAdjustment}})
 	if err != nil {
-		return fmt.Errorf("falha ao serializar a carteira do veículo: %s", err)
+		return err
 	}
 
-	return ctx.GetStub().PutState(compositeKey, vehicleWalletJSON)
+	return emitEvent(ctx, EventNameWalletCredited, WalletCreditedPayload{VehicleID: idcarro, Amount: credits, Balance: vehicleWallet.Credits})
 }
 
 func main() {
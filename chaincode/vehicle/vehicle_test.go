@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseVehicleData(t *testing.T) {
+	maxTs := strconv.FormatInt(maxTimestamp, 10)
+	tooLate := strconv.FormatInt(maxTimestamp+1, 10)
+
+	tests := []struct {
+		name    string
+		args    [7]string // timestamp, latitude, longitude, velocidade, aceleração X, Y e Z
+		wantErr bool
+	}{
+		{"leitura válida", [7]string{"1700000000000", "-22.9", "-43.2", "50", "0.1", "0.2", "9.8"}, false},
+		{"timestamp não numérico", [7]string{"abc", "-22.9", "-43.2", "50", "0.1", "0.2", "9.8"}, true},
+		{"timestamp zero", [7]string{"0", "-22.9", "-43.2", "50", "0.1", "0.2", "9.8"}, true},
+		{"timestamp negativo", [7]string{"-1", "-22.9", "-43.2", "50", "0.1", "0.2", "9.8"}, true},
+		{"timestamp máximo", [7]string{maxTs, "-22.9", "-43.2", "50", "0.1", "0.2", "9.8"}, false},
+		{"timestamp acima do máximo", [7]string{tooLate, "-22.9", "-43.2", "50", "0.1", "0.2", "9.8"}, true},
+		{"latitude no limite", [7]string{"1700000000000", "90", "-180", "50", "0.1", "0.2", "9.8"}, false},
+		{"latitude fora do intervalo", [7]string{"1700000000000", "90.1", "-43.2", "50", "0.1", "0.2", "9.8"}, true},
+		{"longitude fora do intervalo", [7]string{"1700000000000", "-22.9", "180.1", "50", "0.1", "0.2", "9.8"}, true},
+		{"latitude NaN", [7]string{"1700000000000", "NaN", "-43.2", "50", "0.1", "0.2", "9.8"}, true},
+		{"velocidade máxima", [7]string{"1700000000000", "-22.9", "-43.2", "300", "0.1", "0.2", "9.8"}, false},
+		{"velocidade acima do máximo", [7]string{"1700000000000", "-22.9", "-43.2", "300.1", "0.1", "0.2", "9.8"}, true},
+		{"velocidade negativa", [7]string{"1700000000000", "-22.9", "-43.2", "-1", "0.1", "0.2", "9.8"}, true},
+		{"velocidade infinita", [7]string{"1700000000000", "-22.9", "-43.2", "+Inf", "0.1", "0.2", "9.8"}, true},
+		{"aceleração no limite", [7]string{"1700000000000", "-22.9", "-43.2", "50", "-50", "50", "50"}, false},
+		{"aceleração acima do limite", [7]string{"1700000000000", "-22.9", "-43.2", "50", "0.1", "0.2", "-50.01"}, true},
+		{"aceleração não numérica", [7]string{"1700000000000", "-22.9", "-43.2", "50", "x", "0.2", "9.8"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.args
+			got, err := ParseVehicleData(a[0], a[1], a[2], a[3], a[4], a[5], a[6])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVehicleData(%v) erro = %v, esperado erro: %v", a, err, tt.wantErr)
+			}
+			if err == nil && strconv.FormatInt(got.TimeStamp, 10) != a[0] {
+				t.Errorf("TimeStamp = %d, esperado %s", got.TimeStamp, a[0])
+			}
+		})
+	}
+}
+
+func TestVehicleDataValidate(t *testing.T) {
+	valid := VehicleData{TimeStamp: 1700000000000, Latitude: -22.9, Longitude: -43.2, Speed: 50, AccelZ: 9.8}
+
+	tests := []struct {
+		name    string
+		modify  func(d *VehicleData)
+		wantErr bool
+	}{
+		{"leitura válida", func(d *VehicleData) {}, false},
+		{"direção no limite", func(d *VehicleData) { d.Direction = 6.283185307179586 }, false},
+		{"direção acima de 2π", func(d *VehicleData) { d.Direction = 6.3 }, true},
+		{"direção negativa", func(d *VehicleData) { d.Direction = -0.1 }, true},
+		{"timestamp máximo", func(d *VehicleData) { d.TimeStamp = maxTimestamp }, false},
+		{"timestamp acima do máximo", func(d *VehicleData) { d.TimeStamp = maxTimestamp + 1 }, true},
+		{"timestamp zero", func(d *VehicleData) { d.TimeStamp = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := valid
+			tt.modify(&d)
+			if err := d.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() erro = %v, esperado erro: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTelemetryBatch(t *testing.T) {
+	sample := `{"timestamp":%d,"latitude":-22.9,"longitude":-43.2,"speed":50,"accelX":0.1,"accelY":0.2,"accelZ":9.8}`
+	batch := func(n int) string {
+		samples := make([]string, n)
+		for i := range samples {
+			samples[i] = fmt.Sprintf(sample, 1700000000000+int64(i)*1000)
+		}
+		return "[" + strings.Join(samples, ",") + "]"
+	}
+
+	tests := []struct {
+		name    string
+		json    string
+		want    int
+		wantErr bool
+	}{
+		{"uma amostra", batch(1), 1, false},
+		{"lote no limite", batch(maxBatchSize), maxBatchSize, false},
+		{"lote acima do limite", batch(maxBatchSize + 1), 0, true},
+		{"lote vazio", `[]`, 0, true},
+		{"JSON inválido", `[{`, 0, true},
+		{"campo desconhecido", `[{"timestamp":1700000000000,"latitude":-22.9,"longitude":-43.2,"speed":50,"accelX":0.1,"accelY":0.2,"accelZ":9.8,"extra":1}]`, 0, true},
+		{"campo ausente", `[{"timestamp":1700000000000,"latitude":-22.9,"longitude":-43.2,"speed":50,"accelX":0.1,"accelY":0.2}]`, 0, true},
+		{"velocidade zero", `[{"timestamp":1700000000000,"latitude":-22.9,"longitude":-43.2,"speed":0,"accelX":0,"accelY":0,"accelZ":0}]`, 1, false},
+		{"timestamp acima do máximo", fmt.Sprintf("["+sample+"]", maxTimestamp+1), 0, true},
+		{"amostra inválida no meio do lote", "[" + fmt.Sprintf(sample, 1700000000000) + `,{"timestamp":1700000001000,"latitude":-91,"longitude":-43.2,"speed":50,"accelX":0.1,"accelY":0.2,"accelZ":9.8}]`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTelemetryBatch(tt.json)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTelemetryBatch() erro = %v, esperado erro: %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseTelemetryBatch() devolveu %d amostras, esperado %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTimeRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int64
+		wantErr  bool
+	}{
+		{"intervalo de um instante", 1000, 1000, false},
+		{"intervalo completo", 0, maxTimestamp, false},
+		{"início posterior ao fim", 1001, 1000, true},
+		{"início negativo", -1, 1000, true},
+		{"fim acima do máximo", 0, maxTimestamp + 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTimeRange(tt.from, tt.to); (err != nil) != tt.wantErr {
+				t.Errorf("validateTimeRange(%d, %d) erro = %v, esperado erro: %v", tt.from, tt.to, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDetectAnomalousAcceleration(t *testing.T) {
+	policy := DefaultScoringPolicy()
+
+	tests := []struct {
+		name       string
+		timestamps []int64
+		speeds     []float64
+		want       Detection
+	}{
+		{"sem amostras", nil, nil, Detection{Threshold: 30}},
+		{"variação igual ao limite", []int64{0, 1000}, []float64{40, 70}, Detection{Threshold: 30}},
+		{"variação acima do limite", []int64{0, 1000}, []float64{40, 71}, Detection{Detected: true, Credits: -50, Index: 1, Measured: 31, Threshold: 30}},
+		{"frenagem acima do limite", []int64{0, 1000, 2000}, []float64{80, 80, 49}, Detection{Detected: true, Credits: -50, Index: 2, Measured: 31, Threshold: 30}},
+		{"variação no fim do intervalo", []int64{0, 5000}, []float64{40, 71}, Detection{Detected: true, Credits: -50, Index: 1, Measured: 31, Threshold: 30}},
+		{"variação depois do intervalo", []int64{0, 5001}, []float64{40, 71}, Detection{Threshold: 30}},
+		{"variação acumulada dentro do intervalo", []int64{0, 2000, 4000}, []float64{40, 55, 75}, Detection{Detected: true, Credits: -50, Index: 2, Measured: 35, Threshold: 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectAnomalousAcceleration(tt.timestamps, tt.speeds, policy); got != tt.want {
+				t.Errorf("DetectAnomalousAcceleration() = %+v, esperado %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectZigZag(t *testing.T) {
+	policy := DefaultScoringPolicy()
+
+	tests := []struct {
+		name   string
+		accelY []float64
+		accelZ []float64
+		want   Detection
+	}{
+		{"sem amostras", nil, nil, Detection{Threshold: 3}},
+		{"abaixo do mínimo de ocorrências", []float64{0, 0.01, 0.01}, []float64{1, 2, 1}, Detection{Threshold: 3, Index: 0}},
+		{"no mínimo de ocorrências", []float64{0, 0.01, 0.01, 0.008}, []float64{1, 2, 1, 2}, Detection{Detected: true, Credits: -40, Index: 3, Measured: 3, Threshold: 3}},
+		{"aceleração Y abaixo do limite", []float64{0, 0.01, 0.01, 0.0079}, []float64{1, 2, 1, 2}, Detection{Threshold: 3}},
+		{"aceleração Z sem troca", []float64{0, 0.01, 0.01, 0.01}, []float64{1, 2, 1, 1}, Detection{Threshold: 3}},
+		{"acima do mínimo marca a terceira ocorrência", []float64{0, 0.01, 0.01, 0.01, 0.01}, []float64{1, 2, 1, 2, 1}, Detection{Detected: true, Credits: -40, Index: 3, Measured: 4, Threshold: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accelX := make([]float64, len(tt.accelY))
+			if got := DetectZigZag(accelX, tt.accelY, tt.accelZ, policy); got != tt.want {
+				t.Errorf("DetectZigZag() = %+v, esperado %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectSharpTurn(t *testing.T) {
+	policy := DefaultScoringPolicy()
+
+	// trajeto para o norte e depois para o leste, uma curva de 90 graus na amostra 2
+	turnLat := []float64{0, 0.01, 0.01}
+	turnLon := []float64{0, 0, 0.01}
+
+	tests := []struct {
+		name     string
+		speeds   []float64
+		lat, lon []float64
+		detected bool
+		index    int
+	}{
+		{"sem amostras", nil, nil, nil, false, 0},
+		{"curva acima da velocidade limite", []float64{40, 40, 40}, turnLat, turnLon, true, 2},
+		{"curva na velocidade limite", []float64{40, 40, 30}, turnLat, turnLon, false, 0},
+		{"trajeto em linha reta", []float64{40, 40, 40}, []float64{0, 0.01, 0.02}, []float64{0, 0, 0}, false, 0},
+		{"curva leve", []float64{40, 40, 40}, []float64{0, 0.01, 0.02}, []float64{0, 0, 0.005}, false, 0},
+		{"ruído do veículo parado é ignorado", []float64{40, 40, 40, 40}, []float64{0, 0.01, 0.01, 0.02}, []float64{0, 0, 0.00001, 0.00001}, false, 0},
+		{"curva depois de trecho parado", []float64{40, 40, 0, 40}, []float64{0, 0.01, 0.01, 0.01}, []float64{0, 0, 0, 0.01}, true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectSharpTurn(tt.speeds, tt.lat, tt.lon, policy)
+			if got.Detected != tt.detected || got.Index != tt.index {
+				t.Fatalf("DetectSharpTurn() = %+v, esperado detectado %v no índice %d", got, tt.detected, tt.index)
+			}
+			if got.Detected && (got.Credits != policy.SharpTurnPenalty || got.Measured <= policy.SharpTurnDirection) {
+				t.Errorf("DetectSharpTurn() = %+v, esperado penalidade %d e mudança acima de %v", got, policy.SharpTurnPenalty, policy.SharpTurnDirection)
+			}
+		})
+	}
+}
+
+func TestDetectSpeeding(t *testing.T) {
+	policy := DefaultScoringPolicy()
+
+	tests := []struct {
+		name       string
+		timestamps []int64
+		speeds     []float64
+		limits     []float64
+		want       Detection
+	}{
+		{"sem amostras", nil, nil, nil, Detection{}},
+		{"fora de zonas", []int64{0, 1000}, []float64{200, 200}, []float64{0, 0}, Detection{}},
+		{"na tolerância", []int64{0, 1000}, []float64{65, 65}, []float64{60, 60}, Detection{}},
+		// 6 km/h acima do limite por 1 s: -2 x 0,1 km/h·min, arredondado para baixo
+		{"acima da tolerância", []int64{0, 1000}, []float64{66, 50}, []float64{60, 60}, Detection{Detected: true, Credits: -1, Index: 0, Measured: 66, Threshold: 60}},
+		// 30 km/h acima do limite por 10 s, o máximo atribuído a uma amostra
+		{"falha na telemetria", []int64{0, 60000}, []float64{90, 50}, []float64{60, 60}, Detection{Detected: true, Credits: -10, Index: 0, Measured: 90, Threshold: 60}},
+		// a última amostra usa o intervalo desde a anterior: 10 + 20 km/h por 3 s cada
+		{"última amostra", []int64{0, 3000}, []float64{70, 80}, []float64{60, 60}, Detection{Detected: true, Credits: -3, Index: 1, Measured: 80, Threshold: 60}},
+		// amostra única vale speedingSampleDuration
+		{"amostra única", []int64{0}, []float64{120}, []float64{60}, Detection{Detected: true, Credits: -2, Index: 0, Measured: 120, Threshold: 60}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectSpeeding(tt.timestamps, tt.speeds, tt.limits, policy); got != tt.want {
+				t.Errorf("DetectSpeeding() = %+v, esperado %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// creditEntryIndex é o prefixo da chave composta CREDITENTRY~carteira~sequência de cada lançamento
+const creditEntryIndex = "CREDITENTRY"
+
+// maxStatementPageSize limita o número de lançamentos devolvidos por página do extrato
+const maxStatementPageSize = 200
+
+// Motivos dos lançamentos de crédito
+const (
+	ReasonBehaviorEvent     = "BEHAVIOR_EVENT"      // penalidade associada a um BehaviorEvent
+	ReasonSafeDrivingReward = "SAFE_DRIVING_REWARD" // recompensa de um detector sem ocorrências na janela
+	ReasonManualAdjustment  = "MANUAL_ADJUSTMENT"   // ajuste feito por GiveCredits
+	ReasonTransferOut       = "TRANSFER_OUT"        // débito de TransferCredits; Reference é a carteira de destino
+	ReasonTransferIn        = "TRANSFER_IN"         // crédito de TransferCredits; Reference é a carteira de origem
+	ReasonRedemption        = "REDEMPTION"          // débito de RedeemReward; Reference é o voucher emitido
+	ReasonDataPurchase      = "DATA_PURCHASE"       // débito de BuyVehicleData; Reference é a compra
+	ReasonDataSale          = "DATA_SALE"           // crédito de BuyVehicleData na carteira do veículo; Reference é a compra
+)
+
+// orgWalletPrefix identifica as carteiras de organizações, como a de um consumidor de dados.
+// Placas não podem conter ':', por isso essas carteiras nunca colidem com as de veículos.
+const orgWalletPrefix = "org:"
+
+// orgWalletID devolve o identificador da carteira da organização
+func orgWalletID(mspID string) string {
+	return orgWalletPrefix + mspID
+}
+
+// CreditEntry é um lançamento no extrato da carteira. Balance é o saldo após o lançamento.
+type CreditEntry struct {
+	WalletID   string `json:"walletId"`
+	Sequence   int    `json:"sequence"`
+	Amount     int    `json:"amount"`
+	Reason     string `json:"reason"`
+	EventID    string `json:"eventId,omitempty" metadata:"eventId,optional"`     // BehaviorEvent que originou o lançamento
+	Reference  string `json:"reference,omitempty" metadata:"reference,optional"` // referência complementar, como o detector recompensado
+	VehicleID  string `json:"vehicleId,omitempty" metadata:"vehicleId,optional"` // veículo analisado, nos lançamentos de carteiras de motoristas
+	CallerMSP  string `json:"callerMsp"`
+	CallerID   string `json:"callerId"`
+	TxID       string `json:"txId"`
+	TimeStamp  int64  `json:"timestamp"` // timestamp da transação, em milissegundos
+	Balance    int    `json:"balance"`
+	WindowFrom int64  `json:"windowFrom,omitempty" metadata:"windowFrom,optional"`
+	WindowTo   int64  `json:"windowTo,omitempty" metadata:"windowTo,optional"`
+}
+
+// WalletStatement é uma página do extrato da carteira, em ordem de lançamento
+type WalletStatement struct {
+	WalletID            string         `json:"walletId"`
+	Balance             int            `json:"balance"`
+	Entries             []*CreditEntry `json:"entries"`
+	FetchedRecordsCount int32          `json:"fetchedRecordsCount"`
+	Bookmark            string         `json:"bookmark"`
+}
+
+// creditEntryKey monta a chave do lançamento; a sequência tem largura fixa para manter a ordem lexicográfica
+func creditEntryKey(ctx contractapi.TransactionContextInterface, walletID string, sequence int) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(creditEntryIndex, []string{walletID, fmt.Sprintf("%012d", sequence)})
+}
+
+// getWallet recupera a carteira; devolve nil se ela não existir
+func getWallet(ctx contractapi.TransactionContextInterface, walletID string) (*VehicleWallet, error) {
+	walletKey, err := ctx.GetStub().CreateCompositeKey(walletIndex, []string{walletID})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar chave composta para a carteira: %s", err)
+	}
+
+	walletJSON, err := ctx.GetStub().GetState(walletKey)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao recuperar a carteira: %s", err)
+	}
+	if walletJSON == nil {
+		return nil, nil
+	}
+
+	var wallet VehicleWallet
+	err = json.Unmarshal(walletJSON, &wallet)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao desserializar a carteira do veículo: %s", err)
+	}
+	return &wallet, nil
+}
+
+// putWallet grava a carteira
+func putWallet(ctx contractapi.TransactionContextInterface, walletID string, wallet *VehicleWallet) error {
+	walletKey, err := ctx.GetStub().CreateCompositeKey(walletIndex, []string{walletID})
+	if err != nil {
+		return fmt.Errorf("erro ao criar chave composta para a carteira: %s", err)
+	}
+
+	walletJSON, err := json.Marshal(wallet)
+	if err != nil {
+		return fmt.Errorf("falha ao serializar a carteira do veículo: %s", err)
+	}
+
+	err = ctx.GetStub().PutState(walletKey, walletJSON)
+	if err != nil {
+		return fmt.Errorf("falha ao armazenar a carteira do veículo: %s", err)
+	}
+	return nil
+}
+
+// postCreditEntries aplica os lançamentos à carteira e os grava no extrato, completando sequência,
+// chamador, transação e saldo de cada um. Lançamentos de valor zero são ignorados.
+// Como a leitura não enxerga escritas da própria transação, deve ser chamada uma única vez por carteira
+// em cada transação. Uma carteira inexistente é tratada como saldo zero e um lançamento que estouraria
+// o saldo é rejeitado.
+func postCreditEntries(ctx contractapi.TransactionContextInterface, walletID string, entries []*CreditEntry) (*VehicleWallet, error) {
+	wallet, err := getWallet(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		wallet = &VehicleWallet{}
+	}
+
+	caller, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	posted := 0
+	for _, entry := range entries {
+		if entry.Amount == 0 {
+			continue
+		}
+
+		if (entry.Amount > 0 && wallet.Credits > math.MaxInt-entry.Amount) || (entry.Amount < 0 && wallet.Credits < math.MinInt-entry.Amount) {
+			return nil, fmt.Errorf("lançamento de %d créditos excede o limite do saldo da carteira %s", entry.Amount, walletID)
+		}
+		wallet.Credits += entry.Amount
+		entry.WalletID = walletID
+		entry.Sequence = wallet.Sequence
+		entry.CallerMSP = caller.MSPID
+		entry.CallerID = caller.ID
+		entry.TxID = ctx.GetStub().GetTxID()
+		entry.TimeStamp = now
+		entry.Balance = wallet.Credits
+
+		entryKey, err := creditEntryKey(ctx, walletID, entry.Sequence)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao criar chave composta para o lançamento: %s", err)
+		}
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao serializar o lançamento: %s", err)
+		}
+		err = ctx.GetStub().PutState(entryKey, entryJSON)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao armazenar o lançamento: %s", err)
+		}
+
+		wallet.Sequence++
+		posted++
+	}
+
+	if posted == 0 {
+		return wallet, nil
+	}
+	return wallet, putWallet(ctx, walletID, wallet)
+}
+
+// GetWalletStatement devolve uma página do extrato da carteira, do lançamento mais antigo para o mais recente.
+// Deve ser chamada como consulta (evaluate): o Fabric só permite paginação fora de transações de escrita.
+func (s *SmartContract) GetWalletStatement(ctx contractapi.TransactionContextInterface, walletID string, pageSize int32, bookmark string) (*WalletStatement, error) {
+	if pageSize < 1 || pageSize > maxStatementPageSize {
+		return nil, fmt.Errorf("tamanho de página inválido %d: use de 1 a %d", pageSize, maxStatementPageSize)
+	}
+	if err := requireWalletRead(ctx, "GetWalletStatement", walletID); err != nil {
+		return nil, err
+	}
+
+	wallet, err := getWallet(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, fmt.Errorf("carteira %s não encontrada", walletID)
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(creditEntryIndex, []string{walletID}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao consultar o extrato da carteira: %s", err)
+	}
+	defer resultsIterator.Close()
+
+	statement := &WalletStatement{
+		WalletID: walletID,
+		Balance:  wallet.Credits,
+		Entries:  []*CreditEntry{},
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler o extrato da carteira: %s", err)
+		}
+
+		var entry CreditEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao desserializar o lançamento: %s", err)
+		}
+		statement.Entries = append(statement.Entries, &entry)
+	}
+
+	statement.FetchedRecordsCount = metadata.GetFetchedRecordsCount()
+	statement.Bookmark = metadata.GetBookmark()
+	return statement, nil
+}
+
+// requireWalletOwner garante que o chamador é o titular da carteira: o proprietário do veículo, o motorista ou,
+// para carteiras de organização, um consumidor (role=consumer) da própria organização
+func requireWalletOwner(ctx contractapi.TransactionContextInterface, operation string, walletID string) (*Caller, error) {
+	if strings.HasPrefix(walletID, driverWalletPrefix) {
+		caller, _, err := requireDriver(ctx, operation, strings.TrimPrefix(walletID, driverWalletPrefix), false)
+		return caller, err
+	}
+	if strings.HasPrefix(walletID, orgWalletPrefix) {
+		caller, err := requireRole(ctx, operation, RoleConsumer)
+		if err != nil {
+			return nil, err
+		}
+		if orgWalletID(caller.MSPID) != walletID {
+			return nil, permissionDenied(operation, caller, "exige um consumidor titular da carteira "+walletID)
+		}
+		return caller, nil
+	}
+
+	caller, _, err := requireVehicleOwner(ctx, operation, walletID, false)
+	return caller, err
+}
+
+// requireWalletRead garante que o chamador pode consultar a carteira: carteiras de organização e de motorista são
+// visíveis para o titular e administradores; carteiras de veículo exigem o consentimento do proprietário para a categoria scores
+func requireWalletRead(ctx contractapi.TransactionContextInterface, operation string, walletID string) error {
+	if strings.HasPrefix(walletID, orgWalletPrefix) || strings.HasPrefix(walletID, driverWalletPrefix) {
+		caller, err := getCaller(ctx)
+		if err != nil {
+			return err
+		}
+		if caller.HasRole(RoleAdmin) {
+			return nil
+		}
+		_, err = requireWalletOwner(ctx, operation, walletID)
+		return err
+	}
+
+	_, err := requireConsent(ctx, operation, walletID, CategoryScores)
+	return err
+}
+
+// CreateOrganizationWallet cria a carteira da organização do chamador (role=consumer), usada para comprar dados
+func (s *SmartContract) CreateOrganizationWallet(ctx contractapi.TransactionContextInterface) error {
+	caller, err := requireRole(ctx, "CreateOrganizationWallet", RoleConsumer)
+	if err != nil {
+		return err
+	}
+
+	walletID := orgWalletID(caller.MSPID)
+	wallet, err := getWallet(ctx, walletID)
+	if err != nil {
+		return err
+	}
+	if wallet != nil {
+		return fmt.Errorf("carteira %s já existe", walletID)
+	}
+
+	if err := putWallet(ctx, walletID, &VehicleWallet{}); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, EventNameWalletCreated, WalletCreatedPayload{VehicleID: walletID})
+}
+
+// TransferCredits move créditos entre duas carteiras; exige o titular da carteira de origem.
+// A carteira de origem não pode ficar com saldo negativo e ambos os lados são lançados no extrato.
+func (s *SmartContract) TransferCredits(ctx contractapi.TransactionContextInterface, from string, to string, amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("a quantidade transferida deve ser positiva")
+	}
+	if from == to {
+		return fmt.Errorf("as carteiras de origem e destino devem ser diferentes")
+	}
+	if _, err := requireWalletOwner(ctx, "TransferCredits", from); err != nil {
+		return err
+	}
+
+	source, err := getWallet(ctx, from)
+	if err != nil {
+		return err
+	}
+	if source == nil {
+		return fmt.Errorf("carteira %s não encontrada", from)
+	}
+	destination, err := getWallet(ctx, to)
+	if err != nil {
+		return err
+	}
+	if destination == nil {
+		return fmt.Errorf("carteira %s não encontrada", to)
+	}
+	if source.Credits < amount {
+		return fmt.Errorf("saldo insuficiente na carteira %s: %d créditos disponíveis, %d solicitados", from, source.Credits, amount)
+	}
+
+	source, err = postCreditEntries(ctx, from, []*CreditEntry{{Amount: -amount, Reason: ReasonTransferOut, Reference: to}})
+	if err != nil {
+		return err
+	}
+	destination, err = postCreditEntries(ctx, to, []*CreditEntry{{Amount: amount, Reason: ReasonTransferIn, Reference: from}})
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, EventNameCreditsTransferred, CreditsTransferredPayload{
+		From:        from,
+		To:          to,
+		Amount:      amount,
+		FromBalance: source.Credits,
+		ToBalance:   destination.Credits,
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	log "github.com/sirupsen/logrus"
+)
+
+// chaincodeEventFilter matches the names of the events emitted by the vehicle chaincode
+const chaincodeEventFilter = "^(BehaviorDetected|WalletCredited|WalletCreated|CreditsTransferred|RewardRedeemed|DataPurchased|VehicleDataErased)$"
+
+// behaviorEvent mirrors the fields of the chaincode BehaviorEvent used by the client.
+// Event payloads never carry the position or measured value; query the events with consent to get them.
+type behaviorEvent struct {
+	Type        string `json:"type"`
+	Severity    string `json:"severity"`
+	TimeStamp   int64  `json:"timestamp"`
+	CreditDelta int    `json:"creditDelta"`
+}
+
+// behaviorDetectedPayload mirrors the chaincode BehaviorDetected event payload
+type behaviorDetectedPayload struct {
+	VehicleID   string          `json:"vehicleId"`
+	Events      []behaviorEvent `json:"events"`
+	CreditDelta int             `json:"creditDelta"`
+	Balance     int             `json:"balance"`
+}
+
+// walletPayload mirrors the chaincode WalletCredited and WalletCreated event payloads
+type walletPayload struct {
+	VehicleID string `json:"vehicleId"`
+	Amount    int    `json:"amount"`
+	Balance   int    `json:"balance"`
+}
+
+// transferPayload mirrors the chaincode CreditsTransferred event payload
+type transferPayload struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      int    `json:"amount"`
+	FromBalance int    `json:"fromBalance"`
+	ToBalance   int    `json:"toBalance"`
+}
+
+// ListenChaincodeEvents subscribes to the vehicle chaincode events through the gateway network
+// and logs each event once its transaction is committed. The returned function cancels the subscription.
+func ListenChaincodeEvents(contract *gateway.Contract) (func(), error) {
+	registration, events, err := contract.RegisterEvent(chaincodeEventFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for event := range events {
+			handleChaincodeEvent(event)
+		}
+	}()
+
+	return func() { contract.Unregister(registration) }, nil
+}
+
+func handleChaincodeEvent(event *fab.CCEvent) {
+	switch event.EventName {
+	case "BehaviorDetected":
+		var payload behaviorDetectedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			log.Errorf("Failed to decode %s event: %s", event.EventName, err)
+			return
+		}
+		for _, detected := range payload.Events {
+			log.Infof("[%s] %s: %s (%s) at %d, %d credits",
+				event.TxID, payload.VehicleID, detected.Type, detected.Severity, detected.TimeStamp, detected.CreditDelta)
+		}
+		log.Infof("[%s] %s: %d credits, balance %d", event.TxID, payload.VehicleID, payload.CreditDelta, payload.Balance)
+	case "WalletCredited", "WalletCreated":
+		var payload walletPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			log.Errorf("Failed to decode %s event: %s", event.EventName, err)
+			return
+		}
+		log.Infof("[%s] %s %s: %d credits, balance %d", event.TxID, event.EventName, payload.VehicleID, payload.Amount, payload.Balance)
+	case "CreditsTransferred":
+		var payload transferPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			log.Errorf("Failed to decode %s event: %s", event.EventName, err)
+			return
+		}
+		log.Infof("[%s] %d credits from %s (balance %d) to %s (balance %d)",
+			event.TxID, payload.Amount, payload.From, payload.FromBalance, payload.To, payload.ToBalance)
+	default:
+		log.Infof("[%s] %s: %s", event.TxID, event.EventName, string(event.Payload))
+	}
+}
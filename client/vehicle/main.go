@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"math/rand"
 	"os"
@@ -17,47 +19,74 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// telemetrySample mirrors the sample format accepted by the StoreVehicleDataBatch chaincode function
+type telemetrySample struct {
+	TimeStamp int64   `json:"timestamp"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Speed     float64 `json:"speed"`
+	AccelX    float64 `json:"accelX"`
+	AccelY    float64 `json:"accelY"`
+	AccelZ    float64 `json:"accelZ"`
+}
+
+// maxBatchSize mirrors the chaincode limit on samples per StoreVehicleDataBatch transaction
+const maxBatchSize = 500
+
+// analysisWindow mirrors the chaincode grid of AnalyzeDriverBehavior windows: each window lasts analysisWindow ms
+// and starts at a multiple of analysisWindow
+const analysisWindow int64 = 60 * 1000
+
 func main() {
+	batchSize := flag.Int("batch", 10, fmt.Sprintf("number of CSV rows sent in each StoreVehicleDataBatch transaction (at most %d)", maxBatchSize))
+	flag.Parse()
+	if *batchSize < 1 || *batchSize > maxBatchSize {
+		log.Fatalf("Invalid batch size: %d", *batchSize)
+	}
+
 	//configFilePath := os.Args[1]
 	configFilePath := "connection-org.yaml"
 	channelName := "demo"
 	mspID := "INMETROMSP"
 	chaincodeName := "vehicle"
 
-	enrollID := randomString(10)
-	registerEnrollUser(configFilePath, enrollID, mspID)
+	ownerID := randomString(10)
+	deviceID := randomString(10)
+	vehicleID := "ABC1234"
+	vehicleVIN := "9BWZZZ377VT004251"
+
+	// o proprietário registra o veículo e cria sua carteira; o dispositivo envia a telemetria
+	registerEnrollUser(configFilePath, ownerID, mspID, []mspclient.Attribute{
+		{Name: "role", Value: "owner", ECert: true},
+	})
+	registerEnrollUser(configFilePath, deviceID, mspID, []mspclient.Attribute{
+		{Name: "role", Value: "device", ECert: true},
+	})
 
 	//invokeCCgw(configFilePath, channelName, enrollID, mspID, chaincodeName, "CreateVehicleWallet", "ABC1234")
 	//invokeCCgw(configFilePath, channelName, enrollID, mspID, chaincodeName, "CreateCar")
 
-	configBackend := config.FromFile(configFilePath)
-	sdk, err := fabsdk.New(configBackend)
-	if err != nil {
-		log.Error(err)
-	}
-
-	wallet, err := gateway.NewFileSystemWallet(fmt.Sprintf("wallet/%s", mspID))
+	ownerNetwork, err := connectNetwork(configFilePath, channelName, mspID, ownerID)
 	if err != nil {
-		log.Error(err)
+		log.Errorf("Failed to connect owner: %s", err)
 		return
 	}
+	nw, err := connectNetwork(configFilePath, channelName, mspID, deviceID)
 	if err != nil {
-		log.Errorf("Failed to create wallet: %s", err)
+		log.Errorf("Failed to connect device: %s", err)
 		return
 	}
 
-	gw, err := gateway.Connect(
-		gateway.WithSDK(sdk),
-		gateway.WithUser(enrollID),
-		gateway.WithIdentity(wallet, enrollID),
-	)
+	deviceCert, err := walletCertificate(mspID, deviceID)
 	if err != nil {
-		log.Errorf("Failed to create new Gateway: %s", err)
+		log.Errorf("Failed to read device certificate: %s", err)
+		return
 	}
-	// defer gw.Close()
-	nw, err := gw.GetNetwork(channelName)
+	// a telemetria é assinada com a chave do dispositivo registrado para o veículo
+	deviceKey, err := walletSigningKey(mspID, deviceID)
 	if err != nil {
-		log.Errorf("Failed to get network: %s", err)
+		log.Errorf("Failed to read device key: %s", err)
+		return
 	}
 
 	timestamps, lats, lons, vehicleSpeeds, accel_x, accel_y, accel_z, err := ReadCSV()
@@ -65,9 +94,25 @@ func main() {
 		log.Fatalf("Failed to read CSV: %s", err)
 	}
 
-	// criar carteira (fora do loop, deve ser executado somente 1x)
+	// acompanhar detecções e alterações de saldo pelos eventos do chaincode
 	contract := nw.GetContract(chaincodeName)
-	resp, err := contract.SubmitTransaction("CreateVehicleWallet", "ABC1234")
+	stopListening, err := ListenChaincodeEvents(contract)
+	if err != nil {
+		log.Errorf("Failed to register chaincode event listener: %s", err)
+		return
+	}
+	defer stopListening()
+
+	// registrar o veículo vinculado ao dispositivo e criar carteira (fora do loop, deve ser executado somente 1x)
+	ownerContract := ownerNetwork.GetContract(chaincodeName)
+	resp, err := ownerContract.SubmitTransaction("RegisterVehicle", vehicleID, vehicleVIN, deviceCert)
+	if err != nil {
+		log.Errorf("Failed submit transaction: %s", err)
+		return
+	}
+	log.Println(string(resp))
+
+	resp, err = ownerContract.SubmitTransaction("CreateVehicleWallet", vehicleID)
 	if err != nil {
 		log.Errorf("Failed submit transaction: %s", err)
 		return
@@ -81,7 +126,10 @@ func main() {
 	// }
 	// log.Println(string(resp))
 
-	for i := 0; i < 2754; i++ {
+	var batch []telemetrySample
+	// timestamps already stored on the ledger but not yet covered by an analysis window
+	var pending []int64
+	for i := 0; i < len(timestamps); i++ {
 
 		fmt.Printf("Linha %v de %v\n", i, len(timestamps)-1)
 
@@ -111,53 +159,74 @@ func main() {
 		fmt.Println("Aceleração Y:", accel_y[i])
 		fmt.Println("Aceleração Z:", accel_z[i])
 
-		var flag string
-		if i != 0 && i%10 == 0 {
-			flag = "true"
-		} else {
-			flag = "false"
+		sample, err := NewTelemetrySample(unixTimestamp, cleanedLatitude, cleanedLongitude, vehicleSpeeds[i], accel_x[i], accel_y[i], accel_z[i])
+		if err != nil {
+			log.Fatalf("Erro ao converter a linha %d: %s", i, err)
 		}
-		fmt.Println(flag)
+		batch = append(batch, sample)
 
-		// contract = nw.GetContract(chaincodeName)
-		// resp, err = contract.EvaluateTransaction("QueryVehicleData", "ABC1234")
-		// if err != nil {
-		// 	log.Error("Failed submit transaction: %s", err)
-		// 	return
-		// }
-		// log.Info(string(resp))
+		if len(batch) < *batchSize && i < len(timestamps)-1 {
+			continue
+		}
 
-		contract = nw.GetContract(chaincodeName)
-		resp, err := contract.SubmitTransaction("StoreVehicleData", "ABC1234", unixTimestamp, cleanedLatitude, cleanedLongitude, vehicleSpeeds[i], accel_x[i], accel_y[i], accel_z[i], flag)
+		samplesJSON, err := json.Marshal(batch)
 		if err != nil {
-			log.Errorf("Failed submit transaction: %s", err)
-			return
+			log.Fatalf("Erro ao serializar o lote: %s", err)
 		}
-		log.Info(resp)
+		for _, stored := range batch {
+			pending = append(pending, stored.TimeStamp)
+		}
+		batch = batch[:0]
 
-		// contract := nw.GetContract(chaincodeName)
-		// resp, err = contract.SubmitTransaction("StoreSimpleVehicleData", "ABC1234", unixTimestamp, cleanedLatitude, cleanedLongitude, "0", vehicleSpeeds[i], accel_x[i], accel_y[i], accel_z[i], flag)
-		// if err != nil {
-		// 	log.Errorf("Failed submit transaction: %s", err)
-		// 	return
-		// }
-		// log.Info(resp)
+		signature, err := SignTelemetry(deviceKey, vehicleID, string(samplesJSON))
+		if err != nil {
+			log.Fatalf("Erro ao assinar o lote: %s", err)
+		}
+
+		salt, err := NewTelemetrySalt()
+		if err != nil {
+			log.Fatalf("Erro ao gerar o sal do lote: %s", err)
+		}
 
+		// as amostras seguem pelo mapa transiente e ficam na coleção privada; o ledger público guarda só o hash
 		contract = nw.GetContract(chaincodeName)
-		resp, err = contract.SubmitTransaction("AnalyzeDriverBehavior", "ABC1234")
+		txn, err := contract.CreateTransaction("StoreVehicleDataBatch", gateway.WithTransient(map[string][]byte{
+			"samples":   samplesJSON,
+			"signature": []byte(signature),
+			"salt":      salt,
+		}))
 		if err != nil {
-			log.Errorf("Failed submit transaction: %s", err)
+			log.Errorf("Failed to create transaction: %s", err)
 			return
 		}
-		log.Info(resp)
-
-		// contract = nw.GetContract(chaincodeName)
-		resp, err = contract.EvaluateTransaction("QueryVehicleWallet", "ABC1234")
+		resp, err := txn.Submit(vehicleID)
 		if err != nil {
 			log.Errorf("Failed submit transaction: %s", err)
 			return
 		}
-		log.Info(string(resp))
+		log.Info(resp)
+
+		// analisa cada janela completa da grade do chaincode, em sequência. Uma janela só pode ser analisada quando
+		// termina até a última amostra armazenada, por isso a janela da última amostra aguarda as próximas amostras.
+		for len(pending) > 0 {
+			from := pending[0] - pending[0]%analysisWindow
+			to := from + analysisWindow - 1
+			if pending[len(pending)-1] < to {
+				break
+			}
+
+			contract = nw.GetContract(chaincodeName)
+			resp, err = contract.SubmitTransaction("AnalyzeDriverBehavior", vehicleID, strconv.FormatInt(from, 10), strconv.FormatInt(to, 10))
+			if err != nil {
+				log.Errorf("Failed submit transaction: %s", err)
+				return
+			}
+			log.Info(resp)
+
+			for len(pending) > 0 && pending[0] <= to {
+				pending = pending[1:]
+			}
+		}
 
 		// resp, err = contract.EvaluateTransaction("QueryVehicleData", "ABC1234")
 		// if err != nil {
@@ -169,7 +238,9 @@ func main() {
 
 }
 
-func registerEnrollUser(configFilePath, enrollID, mspID string) {
+// registerEnrollUser registers and enrolls a new identity, storing it in the file system wallet.
+// Attributes flagged with ECert are embedded in the enrollment certificate and used by the chaincode access control.
+func registerEnrollUser(configFilePath, enrollID, mspID string, attributes []mspclient.Attribute) {
 	log.Info("Registering User : ", enrollID)
 	sdk, err := fabsdk.New(config.FromFile(configFilePath))
 	if err != nil {
@@ -196,7 +267,7 @@ func registerEnrollUser(configFilePath, enrollID, mspID string) {
 		MaxEnrollments: -1,
 		Affiliation:    "",
 		// CAName:         "INMETROMSP",
-		Attributes: nil,
+		Attributes: attributes,
 		Secret:     enrollID,
 	})
 	if err != nil {
@@ -236,6 +307,47 @@ func registerEnrollUser(configFilePath, enrollID, mspID string) {
 
 }
 
+// connectNetwork connects to the channel through the gateway using an identity stored in the file system wallet
+func connectNetwork(configFilePath, channelName, mspID, enrollID string) (*gateway.Network, error) {
+	sdk, err := fabsdk.New(config.FromFile(configFilePath))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create SDK")
+	}
+
+	wallet, err := gateway.NewFileSystemWallet(fmt.Sprintf("wallet/%s", mspID))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create wallet")
+	}
+
+	gw, err := gateway.Connect(
+		gateway.WithSDK(sdk),
+		gateway.WithUser(enrollID),
+		gateway.WithIdentity(wallet, enrollID),
+	)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create new Gateway")
+	}
+
+	return gw.GetNetwork(channelName)
+}
+
+// walletCertificate returns the PEM enrollment certificate of an identity stored in the file system wallet
+func walletCertificate(mspID, enrollID string) (string, error) {
+	wallet, err := gateway.NewFileSystemWallet(fmt.Sprintf("wallet/%s", mspID))
+	if err != nil {
+		return "", err
+	}
+	identity, err := wallet.Get(enrollID)
+	if err != nil {
+		return "", err
+	}
+	x509Identity, ok := identity.(*gateway.X509Identity)
+	if !ok {
+		return "", fmt.Errorf("identity %s is not an X.509 identity", enrollID)
+	}
+	return x509Identity.Certificate(), nil
+}
+
 func queryCCgw(configFilePath, channelName, userName, mspID, chaincodeName, fcn string) {
 
 	configBackend := config.FromFile(configFilePath)
@@ -253,12 +365,12 @@ func queryCCgw(configFilePath, channelName, userName, mspID, chaincodeName, fcn
 	)
 
 	if err != nil {
-		log.Error("Failed to create new Gateway: %s", err)
+		log.Errorf("Failed to create new Gateway: %s", err)
 	}
 	defer gw.Close()
 	nw, err := gw.GetNetwork(channelName)
 	if err != nil {
-		log.Error("Failed to get network: %s", err)
+		log.Errorf("Failed to get network: %s", err)
 	}
 
 	contract := nw.GetContract(chaincodeName)
@@ -266,7 +378,7 @@ func queryCCgw(configFilePath, channelName, userName, mspID, chaincodeName, fcn
 	resp, err := contract.EvaluateTransaction(fcn)
 
 	if err != nil {
-		log.Error("Failed submit transaction: %s", err)
+		log.Errorf("Failed submit transaction: %s", err)
 	}
 	log.Info(string(resp))
 
@@ -356,21 +468,57 @@ func ReadCSV() ([]string, []string, []string, []string, []string, []string, []st
 	return timestamps, lats, lons, vehicleSpeeds, accelX, accelY, accelZ, nil
 }
 
-// ConvertTimestampToUnix converts a timestamp string to a Unix time string
+// NewTelemetrySample builds a batch sample from the cleaned CSV values
+func NewTelemetrySample(unixTimestamp, latitude, longitude, speed, accelX, accelY, accelZ string) (telemetrySample, error) {
+	var sample telemetrySample
+	var err error
+
+	sample.TimeStamp, err = strconv.ParseInt(unixTimestamp, 10, 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid timestamp %q: %w", unixTimestamp, err)
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		dest  *float64
+	}{
+		{"latitude", latitude, &sample.Latitude},
+		{"longitude", longitude, &sample.Longitude},
+		{"speed", speed, &sample.Speed},
+		{"accel_x", accelX, &sample.AccelX},
+		{"accel_y", accelY, &sample.AccelY},
+		{"accel_z", accelZ, &sample.AccelZ},
+	} {
+		*field.dest, err = strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return sample, fmt.Errorf("invalid %s %q: %w", field.name, field.value, err)
+		}
+	}
+
+	return sample, nil
+}
+
+// ConvertTimestampToUnix converts a timestamp string to a Unix time string in milliseconds.
+// Samples are keyed by vehicle and timestamp on the ledger, so second precision is not
+// enough: consecutive OBD readings are often less than a second apart.
 func ConvertTimestampToUnix(timestamp string) (string, error) {
 	layout := "2006-01-02 15:04:05.000"
 	t, err := time.Parse(layout, timestamp)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse timestamp: %w", err)
 	}
-	return fmt.Sprintf("%d", t.Unix()), nil
+	return fmt.Sprintf("%d", t.UnixMilli()), nil
 }
 
 // SanitizeFloatString removes invalid characters from a float string
 func SanitizeFloatString(input string) (string, error) {
-	// Remove any commas or other invalid characters
-	cleaned := strings.ReplaceAll(input, ".", "")
-	cleaned = strings.ReplaceAll(cleaned, ",", ".")
+	cleaned := strings.TrimSpace(input)
+	// Values using a decimal comma ("-22,93086" or "1.234,5") are converted to the
+	// decimal point notation; values already using a decimal point are kept as is
+	if strings.Contains(cleaned, ",") {
+		cleaned = strings.ReplaceAll(cleaned, ".", "")
+		cleaned = strings.ReplaceAll(cleaned, ",", ".")
+	}
 	if _, err := strconv.ParseFloat(cleaned, 64); err != nil {
 		return "", fmt.Errorf("invalid float string: %s", input)
 	}
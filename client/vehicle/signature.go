@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// walletSigningKey loads the ECDSA private key of an identity stored in the file system wallet
+func walletSigningKey(mspID, enrollID string) (*ecdsa.PrivateKey, error) {
+	wallet, err := gateway.NewFileSystemWallet(fmt.Sprintf("wallet/%s", mspID))
+	if err != nil {
+		return nil, err
+	}
+	identity, err := wallet.Get(enrollID)
+	if err != nil {
+		return nil, err
+	}
+	x509Identity, ok := identity.(*gateway.X509Identity)
+	if !ok {
+		return nil, fmt.Errorf("identity %s is not an X.509 identity", enrollID)
+	}
+
+	block, _ := pem.Decode([]byte(x509Identity.Key()))
+	if block == nil {
+		return nil, fmt.Errorf("identity %s has no PEM private key", enrollID)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key of %s: %w", enrollID, err)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key of %s is not an ECDSA key", enrollID)
+	}
+	return ecdsaKey, nil
+}
+
+// SignTelemetry signs the canonical telemetry message checked by the chaincode: the vehicle plate
+// followed by the transaction arguments joined by "|". The signature is ASN.1 DER, base64 encoded.
+func SignTelemetry(key *ecdsa.PrivateKey, vehicleID string, fields ...string) (string, error) {
+	message := strings.Join(append([]string{vehicleID}, fields...), "|")
+	digest := sha256.Sum256([]byte(message))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// NewTelemetrySalt returns a random salt for the transient map of a telemetry transaction.
+// The chaincode derives a per-sample salt from it and publishes only the salted hash of each sample.
+func NewTelemetrySalt() ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}